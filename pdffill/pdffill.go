@@ -0,0 +1,199 @@
+// pdffill dumps the form fields of a PDF file as JSON, or fills a PDF form
+// from JSON.
+//
+//	usage: pdffill dump pdf-file
+//	       pdffill fill template-pdf json-file output-pdf
+//
+// The dump command writes a JSON object to standard output, with a "fields"
+// key whose value is an array of objects describing the fields of the form
+// that have values (i.e., all but push buttons and signature fields):
+//
+//	{"fields": [
+//	  {"name": "Name", "type": "text", "value": "John Smith"},
+//	  {"name": "State", "type": "combo", "value": "CA", "options": ["CA", "NV"]}
+//	]}
+//
+// The fill command reads a JSON object of the same form, and writes a copy of
+// the template PDF with the specified field values (and with calculated fields
+// recomputed) to the output file.  Only the "name" and "value" of each field
+// are needed.  Push buttons and signature fields are ignored, and an empty
+// value clears a choice field.  The "fields" key can also be an object mapping
+// field names to values, which are set in name order.  The JSON object can
+// also have a "fontSize" key giving the font size to use for text fields whose
+// size is automatic, and a "clones" key giving a list of pages (with
+// zero-based page numbers) to be cloned with pdfform.ClonePage before the
+// fields are filled:
+//
+//	{"clones": [{"page": 1, "prefix": "p2"}],
+//	 "fields": {"Name": "John Smith", "p2.Name": "Jane Smith"}}
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rothskeller/pdf/pdfform"
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+type jsonField struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type,omitempty"`
+	Value   string   `json:"value"`
+	Options []string `json:"options,omitempty"`
+}
+
+type jsonClone struct {
+	Page   int    `json:"page"`
+	Prefix string `json:"prefix"`
+}
+
+type jsonData struct {
+	Clones   []jsonClone     `json:"clones,omitempty"`
+	FontSize float64         `json:"fontSize,omitempty"`
+	Fields   json.RawMessage `json:"fields"`
+}
+
+func main() {
+	var err error
+
+	switch {
+	case len(os.Args) == 3 && os.Args[1] == "dump":
+		err = dump(os.Args[2])
+	case len(os.Args) == 5 && os.Args[1] == "fill":
+		err = fill(os.Args[2], os.Args[3], os.Args[4])
+	default:
+		fmt.Fprint(os.Stderr, "usage: pdffill dump pdf-file\n"+
+			"       pdffill fill template-pdf json-file output-pdf\n")
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// dump writes the fields of the PDF to standard output as JSON.
+func dump(filename string) (err error) {
+	var (
+		fh     *os.File
+		pdf    *pdfstruct.PDF
+		infos  []pdfform.FieldInfo
+		fields = []jsonField{}
+		out    []byte
+	)
+	if fh, err = os.Open(filename); err != nil {
+		return err
+	}
+	defer fh.Close()
+	if pdf, err = pdfstruct.Open(fh); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	if infos, err = pdfform.GetFieldInfo(pdf); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	for _, info := range infos {
+		switch info.Type {
+		case "pushbutton", "signature":
+			continue // they don't have values that can be filled
+		case "checkbox", "radio":
+			if info.Value == "" {
+				info.Value = "Off"
+			}
+		}
+		fields = append(fields, jsonField{
+			Name: info.Name, Type: info.Type, Value: info.Value, Options: info.Options,
+		})
+	}
+	if out, err = json.MarshalIndent(map[string]any{"fields": fields}, "", "  "); err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// fill fills the template PDF with the data in the JSON file, writing the
+// result to the output file.
+func fill(template, jsonfile, output string) (err error) {
+	var (
+		data   jsonData
+		values []jsonField
+		by     []byte
+		in     *os.File
+		out    *os.File
+		pdf    *pdfstruct.PDF
+		infos  []pdfform.FieldInfo
+		types  = make(map[string]string)
+		set    []pdfform.FieldValue
+	)
+	// Read the JSON data.
+	if by, err = os.ReadFile(jsonfile); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(by, &data); err != nil {
+		return fmt.Errorf("%s: %s", jsonfile, err)
+	}
+	if err = json.Unmarshal(data.Fields, &values); err != nil {
+		var valmap map[string]string
+		if err = json.Unmarshal(data.Fields, &valmap); err != nil {
+			return fmt.Errorf("%s: fields must be an array of {name, value} objects or an object", jsonfile)
+		}
+		// Set the fields in name order, so that the result doesn't
+		// depend on map iteration order.
+		var names = make([]string, 0, len(valmap))
+		for name := range valmap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values = append(values, jsonField{Name: name, Value: valmap[name]})
+		}
+	}
+	// Copy the template to the output file.  PDF.Write appends the changes
+	// to the file it was opened from, so we open the copy.
+	if in, err = os.Open(template); err != nil {
+		return err
+	}
+	defer in.Close()
+	if out, err = os.OpenFile(output, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666); err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err = io.Copy(out, in); err != nil {
+		return fmt.Errorf("%s: %s", output, err)
+	}
+	if pdf, err = pdfstruct.Open(out); err != nil {
+		return fmt.Errorf("%s: %s", template, err)
+	}
+	// Apply the changes.
+	for _, c := range data.Clones {
+		if err = pdfform.ClonePage(pdf, c.Page, c.Prefix); err != nil {
+			return fmt.Errorf("clone page %d as %q: %s", c.Page, c.Prefix, err)
+		}
+	}
+	if infos, err = pdfform.GetFieldInfo(pdf); err != nil {
+		return fmt.Errorf("%s: %s", template, err)
+	}
+	for _, info := range infos {
+		types[info.Name] = info.Type
+	}
+	for _, v := range values {
+		if t := types[v.Name]; t != "pushbutton" && t != "signature" {
+			set = append(set, pdfform.FieldValue{Name: v.Name, Value: v.Value})
+		}
+	}
+	if err = pdfform.SetFields(pdf, set, pdfform.Options{FontSize: data.FontSize}); err != nil {
+		return err
+	}
+	if err = pdfform.Calculate(pdf, pdfform.Options{FontSize: data.FontSize}); err != nil {
+		return err
+	}
+	if err = pdf.Write(); err != nil {
+		return fmt.Errorf("%s: %s", output, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,144 @@
+package pdfform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// Calculate recomputes the values of calculated fields, as a PDF reader would
+// after fields are changed.  Fields are computed in the order given by
+// AcroForm[CO], so that later calculations see the results of earlier ones.
+// Only calculations using the standard AFSimple_Calculate function (with SUM,
+// AVG, PRD, MIN, or MAX) are recognized; other calculation scripts are
+// ignored, as are fields locked by signatures.  opts are used for generating
+// the appearances of the changed fields, as in SetFieldWithOptions.  The
+// result is not applied until p.Write is called.
+func Calculate(pdf *pdfstruct.PDF, opts Options) (err error) {
+	var (
+		form   pdfstruct.Dict
+		fields []*fieldNode
+		co     pdfstruct.Array
+		index  = make(map[pdfstruct.Reference]*fieldNode)
+		locks  []fieldLock
+		perm   int
+		xfa    = xfaSync{pdf: pdf}
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if form == nil {
+		return nil
+	}
+	if locks, perm, err = getLocks(pdf, fields); err != nil {
+		return err
+	}
+	if co, err = arrayOf(pdf, form["CO"]); err != nil {
+		return fmt.Errorf("AcroForm[CO]: %s", err)
+	}
+	defer xfa.save()
+	walkFields(fields, func(f *fieldNode) error {
+		if f.ref.Number != 0 {
+			index[f.ref] = f
+		}
+		return nil
+	})
+	for _, c := range co {
+		var (
+			field  *fieldNode
+			script string
+			value  string
+			ok     bool
+		)
+		r, _ := c.(pdfstruct.Reference)
+		if field = index[r]; field == nil || field.fieldType() != "Tx" {
+			continue
+		}
+		if fieldLockError(field, locks, perm) != nil {
+			continue // can't change it without invalidating a signature
+		}
+		if script, err = fieldScript(pdf, field, "C"); err != nil {
+			return fmt.Errorf("%s: %s", field.name, err)
+		}
+		if value, ok = simpleCalculate(fields, script); !ok {
+			continue
+		}
+		if err = setText(pdf, form, field, value, opts); err != nil {
+			return fmt.Errorf("%s: %s", field.name, err)
+		}
+		if err = xfa.setField(field); err != nil {
+			return fmt.Errorf("%s: %s", field.name, err)
+		}
+	}
+	return nil
+}
+
+var (
+	afSimpleCalculateRE = regexp.MustCompile(`AFSimple_Calculate\s*\(\s*(` + jsStringRE +
+		`)\s*,\s*(new\s+Array\s*\(((?:\s*(?:` + jsStringRE + `)\s*,?)*)\)|` + jsStringRE + `)\s*\)`)
+	jsStringLiteralRE = regexp.MustCompile(jsStringRE)
+)
+
+// simpleCalculate evaluates a calculation script that calls
+// AFSimple_Calculate, returning the calculated value.  It returns false if the
+// script is not one it recognizes.
+func simpleCalculate(fields []*fieldNode, script string) (value string, ok bool) {
+	var (
+		m      []string
+		names  []string
+		values []float64
+		result float64
+	)
+	if m = afSimpleCalculateRE.FindStringSubmatch(script); m == nil {
+		return "", false
+	}
+	if strings.HasPrefix(m[2], "new") {
+		for _, lit := range jsStringLiteralRE.FindAllString(m[3], -1) {
+			names = append(names, jsString(lit))
+		}
+	} else {
+		for _, name := range strings.Split(jsString(m[2]), ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	// Each name can be a terminal field or the parent of several.
+	walkFields(fields, func(f *fieldNode) error {
+		if len(names) != 0 && matchName(f.name, names) {
+			var s string
+			switch v := fieldValue(f).(type) {
+			case string:
+				s = decodeText(v)
+			case pdfstruct.Name:
+				s = string(v)
+			}
+			n, _ := parseNumber(s, 0)
+			values = append(values, n)
+		}
+		return nil
+	})
+	switch op := jsString(m[1]); op {
+	case "SUM", "AVG":
+		for _, v := range values {
+			result += v
+		}
+		if op == "AVG" && len(values) != 0 {
+			result /= float64(len(values))
+		}
+	case "PRD":
+		result = 1
+		for _, v := range values {
+			result *= v
+		}
+	case "MIN", "MAX":
+		for i, v := range values {
+			if i == 0 || (op == "MIN" && v < result) || (op == "MAX" && v > result) {
+				result = v
+			}
+		}
+	default:
+		return "", false
+	}
+	return formatFloat(result), true
+}
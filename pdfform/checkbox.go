@@ -2,6 +2,7 @@ package pdfform
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/rothskeller/pdf/pdfstruct"
 )
@@ -30,12 +31,20 @@ Checkboxes are encoded in the PDF as follows:
     >>
 */
 
-// setCheckbox sets the state of a set of radio buttons.  This involves
-// setting V on the parent field and /AS on each of the individual buttons.
-func setCheckbox(pdf *pdfstruct.PDF, fieldref pdfstruct.Reference, field pdfstruct.Dict, value string) (err error) {
-	switch value {
-	case "Off":
-		switch v := field["V"].(type) {
+// setCheckbox sets the state of a checkbox.  This involves setting V on the
+// field and /AS on each of its widgets.  The value must be "Off" or the name of
+// the checkbox's "on" state; "Yes" is accepted as a synonym for the latter.
+func setCheckbox(pdf *pdfstruct.PDF, field *fieldNode, value string) (err error) {
+	var on pdfstruct.Name
+	if on, err = checkboxOnState(pdf, field); err != nil {
+		return err
+	}
+	if value == "Yes" && on != "" {
+		value = string(on)
+	}
+	switch {
+	case value == "Off":
+		switch v := field.dict["V"].(type) {
 		case nil:
 			return nil
 		case pdfstruct.Name:
@@ -43,18 +52,46 @@ func setCheckbox(pdf *pdfstruct.PDF, fieldref pdfstruct.Reference, field pdfstru
 				return nil
 			}
 		}
-		delete(field, "V")
-		field["AS"] = pdfstruct.Name("Off")
-		pdf.UpdateObject(fieldref, field)
-	case "Yes":
-		if v, ok := field["V"].(pdfstruct.Name); ok && v == "Yes" {
+		delete(field.dict, "V")
+	case value == string(on) || (on == "" && value == "Yes"):
+		if v, ok := field.dict["V"].(pdfstruct.Name); ok && string(v) == value {
 			return nil
 		}
-		field["V"] = pdfstruct.Name("Yes")
-		field["AS"] = pdfstruct.Name("Yes")
-		pdf.UpdateObject(fieldref, field)
+		field.dict["V"] = pdfstruct.Name(value)
 	default:
 		return errors.New("value is not valid for field")
 	}
+	field.update(pdf)
+	for _, w := range field.widgets {
+		w.dict["AS"] = pdfstruct.Name(value)
+		if w != field {
+			w.update(pdf)
+		}
+	}
 	return nil
 }
+
+// checkboxOnState returns the name of the "on" appearance state of a checkbox,
+// i.e., the one that isn't "Off".  It returns an empty name if the checkbox
+// doesn't have appearance states.
+func checkboxOnState(pdf *pdfstruct.PDF, field *fieldNode) (on pdfstruct.Name, err error) {
+	for _, w := range field.widgets {
+		var ap, apn pdfstruct.Dict
+		if ap, err = dictOf(pdf, w.dict["AP"]); err != nil {
+			return "", fmt.Errorf("widget[AP]: %s", err)
+		}
+		if r, ok := ap["N"].(pdfstruct.Reference); ok {
+			if obj, err := pdf.Get(r); err == nil {
+				apn, _ = obj.(pdfstruct.Dict)
+			}
+		} else {
+			apn, _ = ap["N"].(pdfstruct.Dict)
+		}
+		for state := range apn {
+			if state != "Off" {
+				return state, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -3,6 +3,7 @@ package pdfform
 import (
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/rothskeller/pdf/pdfstruct"
 )
@@ -32,37 +33,67 @@ Choices are encoded in the PDF as follows:
     >>
 */
 
-// setChoice sets the state of select or combo box.
-func setChoice(pdf *pdfstruct.PDF, fieldref pdfstruct.Reference, field pdfstruct.Dict, value string) (err error) {
+// setChoice sets the state of select or combo box.  An empty value clears the
+// field's selection.
+func setChoice(pdf *pdfstruct.PDF, field *fieldNode, value string) (err error) {
+	if value == "" {
+		if field.dict["V"] != nil {
+			delete(field.dict, "V")
+			field.update(pdf)
+		}
+		return nil
+	}
 	// Update the V in the field.
-	if v, ok := field["V"].(string); ok && v == value {
+	if v, ok := field.dict["V"].(string); ok && decodeText(v) == value {
 		return nil // no change needed
 	}
-	field["V"] = value
-	pdf.UpdateObject(fieldref, field)
-	// If editing is allowed — i.e., values not in the list are acceptable —
-	// we're done.
-	if field["Ff"].(int)&0x60000 != 0 {
-		return nil
+	// Unless editing is allowed — i.e., values not in the list are
+	// acceptable — make sure the value is valid.
+	if field.flags()&choiceEdit == 0 {
+		var opts []string
+		if opts, err = choiceOptions(pdf, field); err != nil {
+			return err
+		}
+		if !slices.Contains(opts, value) {
+			return fmt.Errorf("value %q is not valid for field %q", value, field.name)
+		}
 	}
-	// Make sure the value is valid.
-	var opts pdfstruct.Array
-	switch o := field["Opts"].(type) {
+	field.dict["V"] = encodeText(value)
+	field.update(pdf)
+	return nil
+}
+
+// Choice field flags.
+const (
+	choiceCombo = 1 << 17
+	choiceEdit  = 1 << 18
+)
+
+// choiceOptions returns the list of export values of the options of a choice
+// field.
+func choiceOptions(pdf *pdfstruct.PDF, field *fieldNode) (opts []string, err error) {
+	var list pdfstruct.Array
+	switch o := field.inherited("Opt").(type) {
 	case nil:
-		return errors.New("field[Opts] is not specified")
+		return nil, errors.New("field[Opt] is not specified")
 	case pdfstruct.Reference:
-		if opts, err = pdf.GetArray(o); err != nil {
-			return fmt.Errorf("field[Opts]: %s", err)
+		if list, err = pdf.GetArray(o); err != nil {
+			return nil, fmt.Errorf("field[Opt]: %s", err)
 		}
 	case pdfstruct.Array:
-		opts = o
+		list = o
 	default:
-		return errors.New("field[Opts] is not an Array")
+		return nil, errors.New("field[Opt] is not an Array")
 	}
-	for _, o := range opts {
-		if o, ok := o.(string); ok && o == value {
-			return nil
+	for _, o := range list {
+		// Each option is either a string, or an array of the export
+		// value and the displayed value.
+		if a, ok := o.(pdfstruct.Array); ok && len(a) != 0 {
+			o = a[0]
+		}
+		if o, ok := o.(string); ok {
+			opts = append(opts, decodeText(o))
 		}
 	}
-	return fmt.Errorf("value %q is not valid for field %q", value, field["T"])
+	return opts, nil
 }
@@ -2,6 +2,11 @@ package pdfform
 
 import (
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/rothskeller/pdf/pdfstruct"
 )
@@ -17,185 +22,428 @@ import (
 // contain a dot.  The field names on the new page will be
 // "prefix.oldfieldname".
 //
-// Limitations: only works for PDF files with a flat page structure.  Does not
-// preserve annotations other than fillable fields on the cloned page.
+// All annotations on the page are copied.  Fields with widgets on the page are
+// copied along with their ancestors in the field hierarchy (but only those of
+// their widgets that are on the page), and the copies are placed under a new
+// top-level field named prefix.  The page contents and resources are shared
+// with the original page rather than copied.
 func ClonePage(p *pdfstruct.PDF, pagenum int, prefix string) (err error) {
+	return replicatePage(p, pagenum, []string{prefix}, pagenum+1)
+}
+
+// ReplicatePage makes count copies of the specified page of the PDF document,
+// as ClonePage does, and inserts them before the page whose zero-based index
+// is at (counting pages before the copies are inserted).  If at is the number
+// of pages in the document, the copies are added at the end.  The result is
+// not applied until p.Write is called.
+//
+// The field name prefixes for the copies are prefix followed by the number of
+// the copy, starting with 2 (the original being number 1).  For example, with
+// prefix "p", the field names on the copies are "p2.oldfieldname",
+// "p3.oldfieldname", etc.
+//
+// The copies share the page contents and resources, and the fonts and other
+// resources used by their fields, with the original page and with each other.
+func ReplicatePage(p *pdfstruct.PDF, pagenum, count int, prefix string, at int) (err error) {
+	var prefixes []string
+	if count < 1 {
+		return errors.New("count must be at least 1")
+	}
+	for i := 0; i < count; i++ {
+		prefixes = append(prefixes, prefix+strconv.Itoa(i+2))
+	}
+	return replicatePage(p, pagenum, prefixes, at)
+}
+
+// replicatePage makes copies of the specified page, one for each of the
+// supplied field name prefixes, and inserts them before page index at.
+func replicatePage(p *pdfstruct.PDF, pagenum int, prefixes []string, at int) (err error) {
 	var (
-		newPage    pdfstruct.Dict
-		newPageRef pdfstruct.Reference
-		oldPageRef pdfstruct.Reference
-		fields     pdfstruct.Array
-		newList    pdfstruct.Dict
-		newListRef pdfstruct.Reference
+		form     pdfstruct.Dict
+		fields   []*fieldNode
+		pages    []*pageNode
+		oldPage  *pageNode
+		neighbor *pageNode
+		before   bool
+		template = make(pdfstruct.Dict)
+		annots   pdfstruct.Array
+		onPage   = make(map[pdfstruct.Reference]bool)
+		shared   = make(map[pdfstruct.Reference]pdfstruct.Reference)
+		newRefs  []pdfstruct.Reference
 	)
-	if newPage, newPageRef, oldPageRef, err = clonePage(p, pagenum); err != nil {
+	if form, fields, err = getFieldTree(p); err != nil {
 		return err
 	}
-	if fields, newList, newListRef, err = newFieldList(p, prefix); err != nil {
+	for i, prefix := range prefixes {
+		if strings.Contains(prefix, ".") || prefix == "" {
+			return errors.New("prefix must be non-empty and must not contain a dot")
+		}
+		if findField(fields, prefix) != nil || slices.Contains(prefixes[:i], prefix) {
+			return fmt.Errorf("field %q already exists", prefix)
+		}
+	}
+	if pages, err = getPages(p); err != nil {
 		return err
 	}
-	if newList["Kids"], err = cloneFields(p, fields, oldPageRef, newPageRef, newListRef); err != nil {
+	if pagenum < 0 || pagenum >= len(pages) || at < 0 || at > len(pages) {
+		return errors.New("not that many pages")
+	}
+	oldPage = pages[pagenum]
+	if at < len(pages) {
+		neighbor, before = pages[at], true
+	} else {
+		neighbor = pages[len(pages)-1]
+	}
+	if annots, err = oldPage.annots(p); err != nil {
 		return err
 	}
-	newPage["Annots"] = newList["Kids"]
-	p.UpdateObject(newListRef, newList)
-	return nil
-}
-
-// clonePage creates a clone of the specified page, with everything except the
-// annotations.
-func clonePage(p *pdfstruct.PDF, pagenum int) (newPage pdfstruct.Dict, newPageRef, oldPageRef pdfstruct.Reference, err error) {
-	// Get the Pages dictionary.
-	pagesRef, ok := p.Catalog["Pages"].(pdfstruct.Reference)
-	if !ok {
-		err = errors.New("/Pages is not a reference")
-		return
+	for _, a := range annots {
+		if r, ok := a.(pdfstruct.Reference); ok {
+			onPage[r] = true
+		}
 	}
-	var pages pdfstruct.Dict
-	if pages, err = p.GetDict(pagesRef); err != nil {
-		return
+	// References to the pages, the page tree, the catalog, and the form
+	// are left alone when cloning annotations, rather than copying the
+	// whole document.  So are references to the page resources, the
+	// form's default resources, and the resources of the annotations'
+	// appearance streams, which are shared by all of the copies.
+	for _, pg := range pages {
+		shared[pg.ref] = pg.ref
+		for ref, depth := pg.parent, 0; ref.Number != 0 && depth <= 64; depth++ {
+			var node pdfstruct.Dict
+			if _, ok := shared[ref]; ok {
+				break
+			}
+			shared[ref] = ref
+			if node, err = p.GetDict(ref); err != nil {
+				return err
+			}
+			ref, _ = node["Parent"].(pdfstruct.Reference)
+		}
 	}
-	// Increment the page count.
-	if pcount, ok := pages["Count"].(int); ok {
-		pages["Count"] = pcount + 1
-	} else {
-		err = errors.New("Pages/Count is not an int")
-		return
+	if root, ok := p.Info["Root"].(pdfstruct.Reference); ok {
+		shared[root] = root
 	}
-	// Make a new page and add it to the Pages/Kids array.
-	newPage = make(pdfstruct.Dict)
-	newPageRef = p.CreateObject(newPage)
-	var kids pdfstruct.Array
-	switch k := pages["Kids"].(type) {
-	case pdfstruct.Array:
-		kids = append(k, nil)
-		pages["Kids"] = kids
-	case pdfstruct.Reference:
-		if kids, err = p.GetArray(k); err != nil {
-			return
+	if formref, ok := p.Catalog["AcroForm"].(pdfstruct.Reference); ok {
+		shared[formref] = formref
+	}
+	// The copies get everything in the page dictionary except the Annots,
+	// including attributes that the page inherits, since the copies might
+	// have a different parent.  Indirect objects are shared.
+	for key, ov := range oldPage.dict {
+		if key != "Annots" && key != "Parent" {
+			template[key] = ov
 		}
-		kids = append(kids, nil)
-		p.UpdateObject(k, kids)
-	default:
-		err = errors.New("Pages/Kids is not an Array")
-		return
 	}
-	copy(kids[pagenum+2:], kids[pagenum+1:])
-	kids[pagenum+1] = newPageRef
-	p.UpdateObject(pagesRef, pages)
-	// Get the page we're trying to copy.
-	if pagenum > len(kids)-2 {
-		err = errors.New("not that many pages")
-		return
+	for _, key := range []pdfstruct.Name{"Resources", "MediaBox", "CropBox", "Rotate"} {
+		if _, ok := template[key]; !ok {
+			var ov pdfstruct.Object
+			if ov, err = oldPage.inherited(p, key); err != nil {
+				return fmt.Errorf("page[%s]: %s", key, err)
+			}
+			if ov != nil {
+				template[key] = ov
+			}
+		}
 	}
-	if oldPageRef, ok = kids[pagenum].(pdfstruct.Reference); !ok {
-		err = errors.New("Pages/Kids/# is not a reference")
-		return
+	if err = shareRefs(p, template["Resources"], shared); err != nil {
+		return fmt.Errorf("page[Resources]: %s", err)
 	}
-	var oldPage pdfstruct.Dict
-	if oldPage, err = p.GetDict(oldPageRef); err != nil {
-		return
+	if form != nil {
+		if err = shareRefs(p, form["DR"], shared); err != nil {
+			return fmt.Errorf("AcroForm[DR]: %s", err)
+		}
 	}
-	// Clone the page, with everything except the top Annots key.
-	var clones = make(map[pdfstruct.Reference]pdfstruct.Reference)
-	clones[oldPageRef] = newPageRef
-	clones[pagesRef] = pagesRef
-	for key, ov := range oldPage {
-		if key == "Annots" {
-			continue
+	for _, a := range annots {
+		if err = shareAppearanceResources(p, a, shared); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range prefixes {
+		var (
+			newPage    = copyDirect(template).(pdfstruct.Dict)
+			newPageRef = p.CreateObject(newPage)
+			newAnnots  = pdfstruct.Array{}
+			clones     = maps.Clone(shared)
+		)
+		// References to the old page become references to the new one.
+		clones[oldPage.ref] = newPageRef
+		newPage["Parent"] = neighbor.parent
+		// Clone the fields with widgets on the page.
+		if form != nil {
+			if err = cloneFieldTree(p, form, fields, prefix, onPage, clones); err != nil {
+				return err
+			}
+		}
+		// Clone the annotations.  Widgets have already been cloned with
+		// their fields, and other annotations that refer to them will
+		// get the clones.
+		for _, a := range annots {
+			var na pdfstruct.Object
+			if na, err = cloneObject(p, a, clones); err != nil {
+				return err
+			}
+			newAnnots = append(newAnnots, na)
 		}
-		if newPage[key], err = cloneObject(p, ov, clones); err != nil {
-			return
+		if len(newAnnots) != 0 {
+			newPage["Annots"] = newAnnots
 		}
+		newRefs = append(newRefs, newPageRef)
 	}
-	return
+	return insertPages(p, neighbor, before, newRefs)
 }
 
-// newFieldList creates a new field list as a child of the top-level field list,
-// with the specified name.
-func newFieldList(p *pdfstruct.PDF, name string) (
-	fields pdfstruct.Array, newField pdfstruct.Dict, newFieldRef pdfstruct.Reference, err error,
-) {
-	// Get the AcroForm dictionary.
-	formref, ok := p.Catalog["AcroForm"].(pdfstruct.Reference)
-	if !ok {
-		err = errors.New("AcroForm is not a reference")
-		return
+// shareRefs adds to clones, mapped to themselves, all of the objects reachable
+// from obj, so that cloneObject will share them rather than copying them.
+func shareRefs(p *pdfstruct.PDF, obj pdfstruct.Object, clones map[pdfstruct.Reference]pdfstruct.Reference) (err error) {
+	switch o := obj.(type) {
+	case pdfstruct.Reference:
+		if _, ok := clones[o]; ok {
+			return nil
+		}
+		clones[o] = o
+		if obj, err = p.Get(o); err != nil {
+			return err
+		}
+		return shareRefs(p, obj, clones)
+	case pdfstruct.Array:
+		for _, v := range o {
+			if err = shareRefs(p, v, clones); err != nil {
+				return err
+			}
+		}
+	case pdfstruct.Dict:
+		for _, v := range o {
+			if err = shareRefs(p, v, clones); err != nil {
+				return err
+			}
+		}
+	case pdfstruct.Stream:
+		return shareRefs(p, o.Dict, clones)
 	}
-	form, err := p.GetDict(formref)
-	if err != nil {
-		return
+	return nil
+}
+
+// shareAppearanceResources adds to clones, mapped to themselves, all of the
+// objects reachable from the resources of the appearance streams of an
+// annotation, so that the fonts and images they use are shared by the copies.
+// The appearance streams themselves are still cloned.
+func shareAppearanceResources(p *pdfstruct.PDF, annot pdfstruct.Object, clones map[pdfstruct.Reference]pdfstruct.Reference) (err error) {
+	var dict, ap pdfstruct.Dict
+	if dict, err = dictOf(p, annot); err != nil {
+		return fmt.Errorf("annotation: %s", err)
+	}
+	if ap, err = dictOf(p, dict["AP"]); err != nil {
+		return fmt.Errorf("annotation[AP]: %s", err)
+	}
+	for key, appearance := range ap {
+		var states pdfstruct.Object
+		if r, ok := appearance.(pdfstruct.Reference); ok {
+			if states, err = p.Get(r); err != nil {
+				return fmt.Errorf("annotation[AP][%s]: %s", key, err)
+			}
+		} else {
+			states = appearance
+		}
+		// The appearance is either a stream, or a dictionary of
+		// streams for the annotation's appearance states.
+		var streams []pdfstruct.Object
+		if d, ok := states.(pdfstruct.Dict); ok {
+			for _, v := range d {
+				streams = append(streams, v)
+			}
+		} else {
+			streams = append(streams, states)
+		}
+		for _, stream := range streams {
+			if r, ok := stream.(pdfstruct.Reference); ok {
+				if stream, err = p.Get(r); err != nil {
+					return fmt.Errorf("annotation[AP][%s]: %s", key, err)
+				}
+			}
+			if s, ok := stream.(pdfstruct.Stream); ok {
+				if err = shareRefs(p, s.Dict["Resources"], clones); err != nil {
+					return fmt.Errorf("annotation[AP][%s][Resources]: %s", key, err)
+				}
+			}
+		}
 	}
-	// Make the new field.
-	newField = make(pdfstruct.Dict)
-	newField["T"] = name
-	newFieldRef = p.CreateObject(newField)
-	// Add it to the AcroForm/Fields list.
-	switch f := form["Fields"].(type) {
+	return nil
+}
+
+// copyDirect returns a copy of obj, in which the direct Arrays and Dicts are
+// copied but References are unchanged, so that indirect objects are shared.
+func copyDirect(obj pdfstruct.Object) pdfstruct.Object {
+	switch o := obj.(type) {
 	case pdfstruct.Array:
-		fields = append(f, newFieldRef)
-		form["Fields"] = fields
-		p.UpdateObject(formref, form)
-	case pdfstruct.Reference:
-		if fields, err = p.GetArray(f); err != nil {
-			return
+		var na = make(pdfstruct.Array, len(o))
+		for i, v := range o {
+			na[i] = copyDirect(v)
+		}
+		return na
+	case pdfstruct.Dict:
+		var nd = make(pdfstruct.Dict, len(o))
+		for k, v := range o {
+			nd[k] = copyDirect(v)
 		}
-		fields = append(fields, newFieldRef)
-		p.UpdateObject(f, fields)
+		return nd
 	default:
-		err = errors.New("AcroForm/Fields is not an array")
-		return
+		return obj
 	}
-	return fields, newField, newFieldRef, nil
 }
 
-// cloneFields makes copies of all of the fields in fieldsKids that are on the
-// page indicated by oldPageRef.  The copies have their page set to newPageRef
-// and parent set to newTreeRef.  It adds those copies to newKids and to
-// newPageAnnots.
-func cloneFields(
-	p *pdfstruct.PDF, fieldsKids pdfstruct.Array, oldPageRef, newPageRef, newTreeRef pdfstruct.Reference,
-) (list pdfstruct.Array, err error) {
-	var clones = make(map[pdfstruct.Reference]pdfstruct.Reference)
-	clones[oldPageRef] = newPageRef
-	for _, oldfieldrefobj := range fieldsKids {
-		// Get the field dictionary.
-		oldfieldref, ok := oldfieldrefobj.(pdfstruct.Reference)
-		if !ok {
-			return nil, errors.New("AcroForm/Fields/# is not a reference")
-		}
-		oldfield, err := p.GetDict(oldfieldref)
-		if err != nil {
-			return nil, err
+// cloneFieldTree clones the fields that have widgets on the page being cloned,
+// placing the clones under a new top-level field with the specified name.  It
+// adds cloned fields with calculations to AcroForm[CO].
+func cloneFieldTree(
+	p *pdfstruct.PDF, form pdfstruct.Dict, fields []*fieldNode, name string, onPage map[pdfstruct.Reference]bool,
+	clones map[pdfstruct.Reference]pdfstruct.Reference,
+) (err error) {
+	var (
+		top      = &fieldNode{dict: pdfstruct.Dict{"T": encodeText(name)}, name: name}
+		kids     = pdfstruct.Array{}
+		co       pdfstruct.Array
+		existing = make(map[pdfstruct.Reference]bool)
+	)
+	// Allot the references of all of the clones before cloning anything,
+	// so that references from one field to another (such as the Fields of
+	// a reset button's action, or a Parent) get the single clone of a
+	// field on the page, and the original of any other field, rather than
+	// a separate copy of it.
+	for _, f := range fields {
+		allotClones(p, f, onPage, clones)
+	}
+	for _, f := range fields {
+		collectRefs(f, existing)
+	}
+	for r := range existing {
+		if _, ok := clones[r]; !ok {
+			clones[r] = r
+		}
+	}
+	if !slices.ContainsFunc(fields, func(f *fieldNode) bool { return fieldOnPage(f, onPage) }) {
+		return nil
+	}
+	top.ref = p.CreateObject(top.dict)
+	for _, f := range fields {
+		var kid pdfstruct.Object
+		if kid, err = cloneFieldNode(p, f, top.ref, onPage, clones); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		if kid != nil {
+			kids = append(kids, kid)
+		}
+	}
+	top.dict["Kids"] = kids
+	if err = addField(p, form, nil, top); err != nil {
+		return err
+	}
+	// Add the clones of calculated fields to the calculation order.
+	if co, err = arrayOf(p, form["CO"]); err != nil {
+		return fmt.Errorf("AcroForm[CO]: %s", err)
+	}
+	for _, c := range co {
+		if r, ok := c.(pdfstruct.Reference); ok {
+			if nr := clones[r]; nr.Number != 0 && nr != r {
+				if err = appendRef(p, form, "CO", nr, func() error { return updateForm(p, form) }); err != nil {
+					return fmt.Errorf("AcroForm[CO]: %s", err)
+				}
+			}
 		}
-		// If it's not on the page we're cloning, ignore it.
-		if oldfieldpage, ok := oldfield["P"].(pdfstruct.Reference); !ok || oldfieldpage.Number != oldPageRef.Number || oldfieldpage.Generation != oldPageRef.Generation {
+	}
+	return nil
+}
+
+// cloneFieldNode clones a field and those of its descendants that have
+// widgets on the page being cloned, giving the clone the specified parent.  It
+// returns nil if the field has no widgets on the page.
+func cloneFieldNode(
+	p *pdfstruct.PDF, n *fieldNode, parent pdfstruct.Reference, onPage map[pdfstruct.Reference]bool,
+	clones map[pdfstruct.Reference]pdfstruct.Reference,
+) (_ pdfstruct.Object, err error) {
+	var (
+		nd   = make(pdfstruct.Dict)
+		ref  pdfstruct.Reference
+		kids = pdfstruct.Array{}
+	)
+	if !fieldOnPage(n, onPage) {
+		return nil, nil
+	}
+	if ref = clones[n.ref]; n.ref.Number == 0 || ref.Number == 0 {
+		ref = p.CreateObject(nil)
+	}
+	for key, ov := range n.dict {
+		if key == "Kids" || key == "Parent" {
 			continue
 		}
-		// Clone it and add it to the list.
-		newfieldref, err := cloneField(p, oldfield, oldfieldref, newPageRef, newTreeRef, clones)
-		if err != nil {
+		if nd[key], err = cloneObject(p, ov, clones); err != nil {
+			return nil, err
+		}
+	}
+	nd["Parent"] = parent
+	for _, k := range n.kids {
+		var kid pdfstruct.Object
+		if kid, err = cloneFieldNode(p, k, ref, onPage, clones); err != nil {
 			return nil, err
 		}
-		list = append(list, newfieldref)
+		if kid != nil {
+			kids = append(kids, kid)
+		}
+	}
+	for _, w := range n.widgets {
+		if w != n && onPage[w.ref] {
+			var kid pdfstruct.Object
+			if kid, err = cloneFieldNode(p, w, ref, onPage, clones); err != nil {
+				return nil, err
+			}
+			kids = append(kids, kid)
+		}
 	}
-	return list, nil
+	if len(kids) != 0 {
+		nd["Kids"] = kids
+	}
+	p.UpdateObject(ref, nd)
+	return ref, nil
 }
 
-// cloneField clones a single field and returns a reference to it.
-func cloneField(
-	p *pdfstruct.PDF, oldField pdfstruct.Dict, oldFieldRef, newPageRef, newParentRef pdfstruct.Reference,
-	clones map[pdfstruct.Reference]pdfstruct.Reference,
-) (newFieldRef pdfstruct.Reference, err error) {
-	var newField pdfstruct.Dict
-	if newField, err = cloneDict(p, oldField, clones); err != nil {
+// allotClones creates empty objects for the clones of a field and those of its
+// descendants that cloneFieldNode will clone, and records them in clones.
+func allotClones(
+	p *pdfstruct.PDF, n *fieldNode, onPage map[pdfstruct.Reference]bool, clones map[pdfstruct.Reference]pdfstruct.Reference,
+) {
+	if !fieldOnPage(n, onPage) {
 		return
 	}
-	newField["Page"] = newPageRef
-	newField["Parent"] = newParentRef
-	newFieldRef = p.CreateObject(newField)
-	clones[oldFieldRef] = newFieldRef
-	return newFieldRef, nil
+	if n.ref.Number != 0 {
+		clones[n.ref] = p.CreateObject(nil)
+	}
+	for _, k := range n.kids {
+		allotClones(p, k, onPage, clones)
+	}
+	for _, w := range n.widgets {
+		if w != n && onPage[w.ref] {
+			allotClones(p, w, onPage, clones)
+		}
+	}
+}
+
+// fieldOnPage returns whether the field, or any of its descendants, has a
+// widget on the page whose annotations are in onPage.
+func fieldOnPage(n *fieldNode, onPage map[pdfstruct.Reference]bool) bool {
+	if n.ref.Number != 0 && onPage[n.ref] {
+		return true
+	}
+	for _, w := range n.widgets {
+		if w.ref.Number != 0 && onPage[w.ref] {
+			return true
+		}
+	}
+	for _, k := range n.kids {
+		if fieldOnPage(k, onPage) {
+			return true
+		}
+	}
+	return false
 }
 
 func cloneObject(
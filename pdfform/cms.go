@@ -0,0 +1,396 @@
+package pdfform
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	// Register the hash functions that signatures may use.
+	_ "crypto/sha1"
+	_ "crypto/sha512"
+)
+
+/*
+PDF signatures (SubFilter adbe.pkcs7.detached or ETSI.CAdES.detached) are CMS
+SignedData structures (RFC 5652), DER encoded, with detached content:
+
+    ContentInfo ::= SEQUENCE {
+        contentType OBJECT IDENTIFIER,		[id-signedData]
+        content [0] EXPLICIT SignedData }
+    SignedData ::= SEQUENCE {
+        version INTEGER,
+        digestAlgorithms SET OF AlgorithmIdentifier,
+        encapContentInfo SEQUENCE {
+            eContentType OBJECT IDENTIFIER,	[id-data]
+            eContent [0] EXPLICIT OCTET STRING OPTIONAL },
+        certificates [0] IMPLICIT SET OF Certificate OPTIONAL,
+        crls [1] IMPLICIT SET OF CRL OPTIONAL,
+        signerInfos SET OF SignerInfo }
+    SignerInfo ::= SEQUENCE {
+        version INTEGER,
+        sid SignerIdentifier,			[issuer and serial, or [0] key ID]
+        digestAlgorithm AlgorithmIdentifier,
+        signedAttrs [0] IMPLICIT SET OF Attribute OPTIONAL,
+        signatureAlgorithm AlgorithmIdentifier,
+        signature OCTET STRING,
+        unsignedAttrs [1] IMPLICIT SET OF Attribute OPTIONAL }
+
+When signedAttrs are present, they include the digest of the signed bytes
+(messageDigest), and the signature is computed over the DER encoding of the
+signedAttrs (with a SET tag rather than the [0] tag).  Otherwise the signature
+is computed directly over the signed bytes.  The older adbe.pkcs7.sha1
+SubFilter has the SHA-1 digest of the signed bytes as its eContent, and the
+signature is computed over that.
+*/
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAPSS               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519              = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidDigestAlgorithms     = []struct {
+		oid  asn1.ObjectIdentifier
+		hash crypto.Hash
+	}{
+		{asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, crypto.SHA1},
+		{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, crypto.SHA256},
+		{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, crypto.SHA384},
+		{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, crypto.SHA512},
+	}
+)
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"optional,explicit,tag:0"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// cmsSigningCertificateV2 is the value of the signing certificate attribute
+// (RFC 5035).  Its optional policies are omitted.
+type cmsSigningCertificateV2 struct {
+	Certs []cmsESSCertIDv2
+}
+
+// cmsESSCertIDv2 identifies a certificate by its hash.  The hash algorithm is
+// the default (SHA-256), and the optional issuer and serial are omitted.
+type cmsESSCertIDv2 struct {
+	CertHash []byte
+}
+
+// A cmsSignature is a parsed CMS SignedData structure with a single signer.
+type cmsSignature struct {
+	certs       []*x509.Certificate
+	signer      *x509.Certificate
+	hash        crypto.Hash
+	content     []byte // encapsulated content, if any
+	digest      []byte // messageDigest signed attribute, if any
+	signingTime time.Time
+	signedAttrs []byte // DER of signed attributes, with SET tag
+	sigAlg      asn1.ObjectIdentifier
+	signature   []byte
+}
+
+// parseCMS parses a DER-encoded CMS SignedData structure.  Trailing bytes
+// (such as the zero padding of a signature's Contents) are ignored.
+func parseCMS(der []byte) (cs *cmsSignature, err error) {
+	var (
+		ci cmsContentInfo
+		sd cmsSignedData
+		si cmsSignerInfo
+	)
+	if _, err = asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("ContentInfo: %s", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("content type %s is not SignedData", ci.ContentType)
+	}
+	if _, err = asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("SignedData: %s", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("SignedData has %d signers rather than 1", len(sd.SignerInfos))
+	}
+	si = sd.SignerInfos[0]
+	cs = &cmsSignature{content: sd.EncapContentInfo.EContent, sigAlg: si.SignatureAlgorithm.Algorithm, signature: si.Signature}
+	if len(sd.Certificates.Bytes) != 0 {
+		if cs.certs, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, fmt.Errorf("certificates: %s", err)
+		}
+	}
+	for _, da := range oidDigestAlgorithms {
+		if da.oid.Equal(si.DigestAlgorithm.Algorithm) {
+			cs.hash = da.hash
+		}
+	}
+	if cs.hash == 0 {
+		return nil, fmt.Errorf("digest algorithm %s is not supported", si.DigestAlgorithm.Algorithm)
+	}
+	if cs.signer, err = cmsFindSigner(cs.certs, si.SID); err != nil {
+		return nil, err
+	}
+	if len(si.SignedAttrs.FullBytes) != 0 {
+		var attrs []cmsAttribute
+		// The signature covers the attributes with their natural SET
+		// tag, not the implicit [0] tag used in the SignerInfo.
+		cs.signedAttrs = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+		if _, err = asn1.UnmarshalWithParams(cs.signedAttrs, &attrs, "set"); err != nil {
+			return nil, fmt.Errorf("signed attributes: %s", err)
+		}
+		for _, attr := range attrs {
+			if len(attr.Values) == 0 {
+				continue
+			}
+			switch {
+			case attr.Type.Equal(oidMessageDigest):
+				if _, err = asn1.Unmarshal(attr.Values[0].FullBytes, &cs.digest); err != nil {
+					return nil, fmt.Errorf("messageDigest: %s", err)
+				}
+			case attr.Type.Equal(oidSigningTime):
+				if _, err = asn1.Unmarshal(attr.Values[0].FullBytes, &cs.signingTime); err != nil {
+					return nil, fmt.Errorf("signingTime: %s", err)
+				}
+			}
+		}
+		if cs.digest == nil {
+			return nil, errors.New("signed attributes do not include messageDigest")
+		}
+	}
+	return cs, nil
+}
+
+// cmsFindSigner returns the certificate identified by a SignerIdentifier.
+func cmsFindSigner(certs []*x509.Certificate, sid asn1.RawValue) (cert *x509.Certificate, err error) {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		// subjectKeyIdentifier [0] IMPLICIT OCTET STRING
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, sid.Bytes) {
+				return c, nil
+			}
+		}
+		return nil, errors.New("signer certificate is not included")
+	}
+	var ias cmsIssuerAndSerial
+	if _, err = asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+		return nil, fmt.Errorf("signer identifier: %s", err)
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.Serial) == 0 {
+			return c, nil
+		}
+	}
+	return nil, errors.New("signer certificate is not included")
+}
+
+// verify checks that the signature is a valid signature, by the signer
+// certificate, of the specified signed content.
+func (cs *cmsSignature) verify(content []byte) (err error) {
+	var signed = content
+
+	if cs.signedAttrs != nil {
+		h := cs.hash.New()
+		h.Write(content)
+		if !bytes.Equal(h.Sum(nil), cs.digest) {
+			return errors.New("digest of signed bytes does not match signature")
+		}
+		signed = cs.signedAttrs
+	}
+	var digest []byte
+	if _, ok := cs.signer.PublicKey.(ed25519.PublicKey); !ok {
+		h := cs.hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	switch pub := cs.signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if cs.sigAlg.Equal(oidRSAPSS) {
+			err = rsa.VerifyPSS(pub, cs.hash, digest, cs.signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, cs.hash, digest, cs.signature)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, cs.signature) {
+			err = errors.New("ECDSA verification failure")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, signed, cs.signature) {
+			err = errors.New("Ed25519 verification failure")
+		}
+	default:
+		err = fmt.Errorf("public key type %T is not supported", pub)
+	}
+	if err != nil {
+		return fmt.Errorf("signature is not valid: %s", err)
+	}
+	return nil
+}
+
+// signCMS creates a DER-encoded CMS SignedData structure containing a detached
+// signature of content, by signer, whose certificate is chain[0].  The rest of
+// the chain is included in the structure so that verifiers can find the path
+// to a trusted root.
+func signCMS(content []byte, signer crypto.Signer, chain []*x509.Certificate, hash crypto.Hash, signingTime time.Time, rand io.Reader) (der []byte, err error) {
+	var (
+		digestAlg pkix.AlgorithmIdentifier
+		sigAlg    pkix.AlgorithmIdentifier
+		attrs     [][]byte
+		attrBytes []byte
+		attrSet   []byte
+		signature []byte
+		certs     []byte
+		signOpts  crypto.SignerOpts = hash
+	)
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			sigAlg.Algorithm = oidECDSAWithSHA256
+		case crypto.SHA384:
+			sigAlg.Algorithm = oidECDSAWithSHA384
+		case crypto.SHA512:
+			sigAlg.Algorithm = oidECDSAWithSHA512
+		default:
+			return nil, fmt.Errorf("hash %s is not supported with ECDSA", hash)
+		}
+	case ed25519.PublicKey:
+		// Ed25519 signs the message itself, and is paired with SHA-512
+		// for the message digest (RFC 8419).
+		hash, signOpts = crypto.SHA512, crypto.Hash(0)
+		sigAlg.Algorithm = oidEd25519
+	default:
+		return nil, fmt.Errorf("public key type %T is not supported", signer.Public())
+	}
+	for _, da := range oidDigestAlgorithms {
+		if da.hash == hash {
+			digestAlg.Algorithm = da.oid
+		}
+	}
+	if digestAlg.Algorithm == nil {
+		return nil, fmt.Errorf("hash %s is not supported", hash)
+	}
+	// Build the signed attributes: the content type, the signing time,
+	// the digest of the content, and the hash of the signer certificate.
+	h := hash.New()
+	h.Write(content)
+	certHash := sha256.Sum256(chain[0].Raw)
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, signingTime.UTC()},
+		{oidMessageDigest, h.Sum(nil)},
+		{oidSigningCertificateV2, cmsSigningCertificateV2{Certs: []cmsESSCertIDv2{{CertHash: certHash[:]}}}},
+	} {
+		var value, enc []byte
+		if value, err = asn1.Marshal(attr.value); err != nil {
+			return nil, err
+		}
+		if enc, err = asn1.Marshal(cmsAttribute{Type: attr.oid, Values: []asn1.RawValue{{FullBytes: value}}}); err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, enc)
+	}
+	// DER requires the elements of a SET OF to be sorted by their
+	// encodings.
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	attrBytes = bytes.Join(attrs, nil)
+	if attrSet, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrBytes}); err != nil {
+		return nil, err
+	}
+	// Sign the signed attributes.
+	if signOpts.HashFunc() != 0 {
+		h = hash.New()
+		h.Write(attrSet)
+		signature, err = signer.Sign(rand, h.Sum(nil), signOpts)
+	} else {
+		signature, err = signer.Sign(rand, attrSet, signOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing: %s", err)
+	}
+	// Assemble the structure.
+	var sid []byte
+	if sid, err = asn1.Marshal(cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: chain[0].RawIssuer}, Serial: chain[0].SerialNumber}); err != nil {
+		return nil, err
+	}
+	for _, cert := range chain {
+		certs = append(certs, cert.Raw...)
+	}
+	var sd = cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []cmsSignerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrBytes},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}
+	var sdDER []byte
+	if sdDER, err = asn1.Marshal(sd); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdDER},
+	})
+}
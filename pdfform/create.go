@@ -0,0 +1,557 @@
+package pdfform
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+	"github.com/rothskeller/pdf/pdftext"
+)
+
+// NewField describes a form field to be created by AddTextField, AddCheckbox,
+// AddRadioGroup, AddComboBox, AddListBox, AddPushButton, or AddSignatureField.
+type NewField struct {
+	// Name is the fully qualified name of the field.  Non-terminal fields
+	// are created as needed to hold it.
+	Name string
+	// Page is the zero-based index of the page on which the field appears.
+	// It is ignored for radio groups.
+	Page int
+	// Rect is the rectangle of the field on the page, in default user
+	// space: lower left x, lower left y, upper right x, upper right y.  It
+	// is ignored for radio groups.
+	Rect [4]float64
+	// FontSize is the font size for text, choice, and push button fields.
+	// Zero means the size is automatic:  appearances use the font size
+	// given to SetField, or if none is given, a size that fits the field
+	// height.
+	FontSize float64
+	// Flags are the field flags (Ff).  The flags that identify radio
+	// buttons and push buttons are added automatically.
+	Flags int
+	// Value is the initial value of the field.
+	Value string
+	// Options is the list of options for a combo box or list box.
+	Options []string
+	// OnState is the value of a checkbox when it is checked.  It defaults
+	// to "Yes".
+	OnState string
+	// Caption is the caption of a push button.
+	Caption string
+	// Buttons are the buttons of a radio group.
+	Buttons []RadioButton
+}
+
+// RadioButton describes one button in a radio group.
+type RadioButton struct {
+	// Page is the zero-based index of the page on which the button
+	// appears.
+	Page int
+	// Rect is the rectangle of the button on the page.
+	Rect [4]float64
+	// State is the value of the radio group when this button is selected.
+	State string
+}
+
+// Button field flags, in addition to those in info.go.
+const buttonNoToggleToOff = 1 << 14
+
+// AddTextField adds a text field to the form.  The result is not applied until
+// p.Write is called.
+func AddTextField(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	var (
+		form    pdfstruct.Dict
+		field   *fieldNode
+		pages   []*pageNode
+		fontRef pdfstruct.Reference
+	)
+	if pages, err = newFieldPages(pdf, nf.Page); err != nil {
+		return err
+	}
+	if form, field, err = newField(pdf, nf.Name, pdfstruct.Dict{
+		"FT": pdfstruct.Name("Tx"),
+		"Ff": nf.Flags,
+		"DA": fmt.Sprintf("/Helv %g Tf 0 g", nf.FontSize),
+	}); err != nil {
+		return err
+	}
+	if err = addWidget(pdf, pages[nf.Page], field, nf.Rect); err != nil {
+		return err
+	}
+	if fontRef, err = textHelvetica(pdf, form); err != nil {
+		return err
+	}
+	if nf.Value != "" {
+		field.dict["V"] = encodeText(nf.Value)
+	}
+	return textWidgetAppearance(pdf, field, nf.Value, fontRef, newFieldFontSize(nf.FontSize, nf.Rect))
+}
+
+// AddCheckbox adds a checkbox to the form.  It is checked if nf.Value is its
+// on state (or "Yes").  The result is not applied until p.Write is called.
+func AddCheckbox(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	var (
+		form    pdfstruct.Dict
+		field   *fieldNode
+		pages   []*pageNode
+		fontRef pdfstruct.Reference
+		on      = nf.OnState
+	)
+	if on == "" {
+		on = "Yes"
+	} else if on == "Off" {
+		return errors.New("checkbox on state cannot be Off")
+	}
+	if pages, err = newFieldPages(pdf, nf.Page); err != nil {
+		return err
+	}
+	if form, field, err = newField(pdf, nf.Name, pdfstruct.Dict{
+		"FT": pdfstruct.Name("Btn"),
+		"Ff": nf.Flags &^ (buttonRadio | buttonPushButton),
+		"DA": "/ZaDb 0 Tf 0 g",
+		"MK": pdfstruct.Dict{"CA": string(checkGlyph.code)},
+	}); err != nil {
+		return err
+	}
+	if err = addWidget(pdf, pages[nf.Page], field, nf.Rect); err != nil {
+		return err
+	}
+	if fontRef, err = zapfDingbats(pdf, form); err != nil {
+		return err
+	}
+	field.dict["AP"] = buttonAppearance(pdf, nf.Rect, on, checkGlyph, fontRef)
+	if nf.Value == on || nf.Value == "Yes" {
+		field.dict["V"] = pdfstruct.Name(on)
+		field.dict["AS"] = pdfstruct.Name(on)
+	} else {
+		field.dict["AS"] = pdfstruct.Name("Off")
+	}
+	field.update(pdf)
+	return nil
+}
+
+// AddRadioGroup adds a group of radio buttons to the form, described by
+// nf.Buttons.  The button whose state is nf.Value, if any, is selected.  The
+// result is not applied until p.Write is called.
+func AddRadioGroup(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	var (
+		form    pdfstruct.Dict
+		field   *fieldNode
+		pages   []*pageNode
+		fontRef pdfstruct.Reference
+		states  = make(map[string]bool)
+	)
+	if len(nf.Buttons) == 0 {
+		return errors.New("radio group has no buttons")
+	}
+	for _, b := range nf.Buttons {
+		if b.State == "" || b.State == "Off" {
+			return fmt.Errorf("radio button state %q is not valid", b.State)
+		}
+		if states[b.State] {
+			return fmt.Errorf("radio button state %q is used twice", b.State)
+		}
+		states[b.State] = true
+		if pages, err = newFieldPages(pdf, b.Page); err != nil {
+			return err
+		}
+	}
+	if form, field, err = newField(pdf, nf.Name, pdfstruct.Dict{
+		"FT":   pdfstruct.Name("Btn"),
+		"Ff":   (nf.Flags | buttonRadio | buttonNoToggleToOff) &^ buttonPushButton,
+		"DA":   "/ZaDb 0 Tf 0 g",
+		"Kids": pdfstruct.Array{},
+	}); err != nil {
+		return err
+	}
+	if states[nf.Value] {
+		field.dict["V"] = pdfstruct.Name(nf.Value)
+		field.update(pdf)
+	}
+	if fontRef, err = zapfDingbats(pdf, form); err != nil {
+		return err
+	}
+	for _, b := range nf.Buttons {
+		var widget = &fieldNode{name: field.name, parent: field, dict: pdfstruct.Dict{
+			"Parent": field.ref,
+			"MK":     pdfstruct.Dict{"CA": string(radioGlyph.code)},
+			"AS":     pdfstruct.Name("Off"),
+		}}
+		widget.dict["AP"] = buttonAppearance(pdf, b.Rect, b.State, radioGlyph, fontRef)
+		if b.State == nf.Value {
+			widget.dict["AS"] = pdfstruct.Name(b.State)
+		}
+		widget.ref = pdf.CreateObject(widget.dict)
+		if err = appendRef(pdf, field.dict, "Kids", widget.ref, func() error { field.update(pdf); return nil }); err != nil {
+			return fmt.Errorf("%s[Kids]: %s", field.name, err)
+		}
+		field.widgets = append(field.widgets, widget)
+		if err = addWidget(pdf, pages[b.Page], widget, b.Rect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddComboBox adds a combo box to the form, with the options listed in
+// nf.Options.  The result is not applied until p.Write is called.
+func AddComboBox(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	return addChoice(pdf, nf, nf.Flags|choiceCombo)
+}
+
+// AddListBox adds a list box to the form, with the options listed in
+// nf.Options.  The result is not applied until p.Write is called.
+func AddListBox(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	return addChoice(pdf, nf, nf.Flags&^(choiceCombo|choiceEdit))
+}
+
+// addChoice adds a choice field to the form.  Its initial appearance shows its
+// value.
+func addChoice(pdf *pdfstruct.PDF, nf NewField, flags int) (err error) {
+	var (
+		form    pdfstruct.Dict
+		field   *fieldNode
+		pages   []*pageNode
+		fontRef pdfstruct.Reference
+		opts    = pdfstruct.Array{}
+	)
+	if nf.Value != "" && flags&choiceEdit == 0 && !slices.Contains(nf.Options, nf.Value) {
+		return fmt.Errorf("value %q is not one of the options", nf.Value)
+	}
+	if pages, err = newFieldPages(pdf, nf.Page); err != nil {
+		return err
+	}
+	for _, o := range nf.Options {
+		opts = append(opts, encodeText(o))
+	}
+	if form, field, err = newField(pdf, nf.Name, pdfstruct.Dict{
+		"FT":  pdfstruct.Name("Ch"),
+		"Ff":  flags,
+		"Opt": opts,
+		"DA":  fmt.Sprintf("/Helv %g Tf 0 g", nf.FontSize),
+	}); err != nil {
+		return err
+	}
+	if err = addWidget(pdf, pages[nf.Page], field, nf.Rect); err != nil {
+		return err
+	}
+	if fontRef, err = textHelvetica(pdf, form); err != nil {
+		return err
+	}
+	if nf.Value != "" {
+		field.dict["V"] = encodeText(nf.Value)
+	}
+	return textWidgetAppearance(pdf, field, nf.Value, fontRef, newFieldFontSize(nf.FontSize, nf.Rect))
+}
+
+// AddPushButton adds a push button to the form, with the caption nf.Caption.
+// The result is not applied until p.Write is called.
+func AddPushButton(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	var (
+		form    pdfstruct.Dict
+		field   *fieldNode
+		pages   []*pageNode
+		fontRef pdfstruct.Reference
+		buf     bytes.Buffer
+	)
+	if pages, err = newFieldPages(pdf, nf.Page); err != nil {
+		return err
+	}
+	if form, field, err = newField(pdf, nf.Name, pdfstruct.Dict{
+		"FT": pdfstruct.Name("Btn"),
+		"Ff": (nf.Flags | buttonPushButton) &^ buttonRadio,
+		"DA": fmt.Sprintf("/Helv %g Tf 0 g", nf.FontSize),
+		"MK": pdfstruct.Dict{
+			"BC": pdfstruct.Array{0},
+			"BG": pdfstruct.Array{0.75},
+			"CA": encodeText(nf.Caption),
+		},
+	}); err != nil {
+		return err
+	}
+	if err = addWidget(pdf, pages[nf.Page], field, nf.Rect); err != nil {
+		return err
+	}
+	if fontRef, err = textHelvetica(pdf, form); err != nil {
+		return err
+	}
+	// Draw a gray box with a black border, with the caption centered in
+	// it.
+	var w, h = nf.Rect[2] - nf.Rect[0], nf.Rect[3] - nf.Rect[1]
+	var style = pdftext.Style{
+		Font: "Helvetica", FontSize: newFieldFontSize(nf.FontSize, nf.Rect), HAlign: "center", VAlign: "center",
+	}
+	fmt.Fprintf(&buf, "q 0.75 g 0 0 %f %f re f 0 G 1 w 0.5 0.5 %f %f re S Q\n", w, h, w-1, h-1)
+	pdftext.DrawContent(&buf, []pdftext.Span{{Text: nf.Caption}}, 0, 0, w, h, style, map[string]string{"Helvetica": "Helv"})
+	field.dict["AP"] = pdfstruct.Dict{"N": pdf.CreateObject(appearanceStream(w, h, "Helv", fontRef, buf.Bytes()))}
+	field.update(pdf)
+	return nil
+}
+
+// AddSignatureField adds an unsigned signature field to the form.  If nf.Rect
+// is empty, the field is invisible.  The field can be signed with Sign.  The
+// result is not applied until p.Write is called.
+func AddSignatureField(pdf *pdfstruct.PDF, nf NewField) (err error) {
+	_, err = addSignatureField(pdf, nf)
+	return err
+}
+
+// addSignatureField adds an unsigned signature field to the form and returns
+// it.
+func addSignatureField(pdf *pdfstruct.PDF, nf NewField) (field *fieldNode, err error) {
+	var pages []*pageNode
+
+	if pages, err = newFieldPages(pdf, nf.Page); err != nil {
+		return nil, err
+	}
+	if _, field, err = newField(pdf, nf.Name, pdfstruct.Dict{
+		"FT": pdfstruct.Name("Sig"),
+		"Ff": nf.Flags,
+	}); err != nil {
+		return nil, err
+	}
+	if err = addWidget(pdf, pages[nf.Page], field, nf.Rect); err != nil {
+		return nil, err
+	}
+	var w, h = nf.Rect[2] - nf.Rect[0], nf.Rect[3] - nf.Rect[1]
+	field.dict["AP"] = pdfstruct.Dict{"N": pdf.CreateObject(appearanceStream(w, h, "", pdfstruct.Reference{}, nil))}
+	field.widgets = []*fieldNode{field}
+	field.update(pdf)
+	return field, nil
+}
+
+// newFieldPages returns the pages of the document, after verifying that
+// pagenum is a valid page index.
+func newFieldPages(pdf *pdfstruct.PDF, pagenum int) (pages []*pageNode, err error) {
+	if pages, err = getPages(pdf); err != nil {
+		return nil, err
+	}
+	if pagenum < 0 || pagenum >= len(pages) {
+		return nil, errors.New("not that many pages")
+	}
+	return pages, nil
+}
+
+// newField creates a new terminal field with the specified name, whose field
+// dictionary starts with the supplied entries, and adds it to the form.  It
+// creates the form, and any non-terminal fields needed to hold the new field,
+// if they don't already exist.
+func newField(pdf *pdfstruct.PDF, name string, dict pdfstruct.Dict) (form pdfstruct.Dict, field *fieldNode, err error) {
+	var (
+		fields  []*fieldNode
+		parent  *fieldNode
+		partial string
+	)
+	if name == "" {
+		return nil, nil, errors.New("field name is empty")
+	}
+	if form, err = getForm(pdf); err != nil {
+		return nil, nil, err
+	}
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return nil, nil, err
+	}
+	if findField(fields, name) != nil {
+		return nil, nil, fmt.Errorf("field %q already exists", name)
+	}
+	if parent, partial, err = fieldParent(pdf, form, fields, name); err != nil {
+		return nil, nil, err
+	}
+	dict["T"] = encodeText(partial)
+	if parent != nil {
+		dict["Parent"] = parent.ref
+	}
+	field = &fieldNode{dict: dict, name: name, parent: parent}
+	field.ref = pdf.CreateObject(dict)
+	if err = addField(pdf, form, parent, field); err != nil {
+		return nil, nil, err
+	}
+	return form, field, nil
+}
+
+// getForm returns the AcroForm dictionary of the PDF, creating it if the PDF
+// doesn't have one.
+func getForm(pdf *pdfstruct.PDF) (form pdfstruct.Dict, err error) {
+	if form, err = dictOf(pdf, pdf.Catalog["AcroForm"]); err != nil {
+		return nil, fmt.Errorf("AcroForm: %s", err)
+	}
+	if form != nil {
+		return form, nil
+	}
+	form = pdfstruct.Dict{"Fields": pdfstruct.Array{}}
+	pdf.Catalog["AcroForm"] = pdf.CreateObject(form)
+	return form, updateCatalog(pdf)
+}
+
+// fieldParent returns the parent field for a new field with the specified
+// fully qualified name, creating non-terminal fields as needed, and the
+// partial name of the new field.  The parent is nil for a top-level field.
+func fieldParent(
+	pdf *pdfstruct.PDF, form pdfstruct.Dict, fields []*fieldNode, name string,
+) (parent *fieldNode, partial string, err error) {
+	var parts = strings.Split(name, ".")
+
+	for _, part := range parts[:len(parts)-1] {
+		var (
+			next   *fieldNode
+			prefix = part
+		)
+		if part == "" {
+			return nil, "", fmt.Errorf("field name %q is not valid", name)
+		}
+		if parent != nil {
+			prefix = parent.name + "." + part
+			fields = parent.kids
+		}
+		for _, f := range fields {
+			if f.name == prefix {
+				next = f
+				break
+			}
+		}
+		if next == nil {
+			next = &fieldNode{dict: pdfstruct.Dict{"T": encodeText(part), "Kids": pdfstruct.Array{}}, name: prefix, parent: parent}
+			if parent != nil {
+				next.dict["Parent"] = parent.ref
+			}
+			next.ref = pdf.CreateObject(next.dict)
+			if err = addField(pdf, form, parent, next); err != nil {
+				return nil, "", err
+			}
+		} else if next.terminal() && (next.dict["Kids"] == nil || len(next.widgets) != 1 || next.widgets[0] != next) {
+			// A field with an empty Kids array looks like a
+			// terminal field, but can hold new kids.  Anything else
+			// that looks like a terminal field is one.
+			return nil, "", fmt.Errorf("%q is a terminal field", prefix)
+		} else if next.ref.Number == 0 {
+			return nil, "", fmt.Errorf("field %q is not a Reference", prefix)
+		}
+		parent = next
+	}
+	if partial = parts[len(parts)-1]; partial == "" {
+		return nil, "", fmt.Errorf("field name %q is not valid", name)
+	}
+	return parent, partial, nil
+}
+
+// addWidget makes the node into a printable widget annotation with the
+// specified rectangle, and adds it to the page.
+func addWidget(pdf *pdfstruct.PDF, page *pageNode, widget *fieldNode, rect [4]float64) (err error) {
+	widget.dict["Type"] = pdfstruct.Name("Annot")
+	widget.dict["Subtype"] = pdfstruct.Name("Widget")
+	widget.dict["Rect"] = pdfstruct.Array{rect[0], rect[1], rect[2], rect[3]}
+	widget.dict["P"] = page.ref
+	widget.dict["F"] = annotPrint
+	pdf.UpdateObject(widget.ref, widget.dict)
+	return page.addAnnot(pdf, widget.ref)
+}
+
+// newFieldFontSize returns the font size to use for the initial appearance of
+// a new field.  If the field's font size is automatic, it picks one that fits
+// the field height.
+func newFieldFontSize(size float64, rect [4]float64) float64 {
+	if size != 0 {
+		return size
+	}
+	return min(12, max(4, rect[3]-rect[1]-4))
+}
+
+// textWidgetAppearance generates the appearance of a new text or choice field,
+// showing the supplied value.
+func textWidgetAppearance(pdf *pdfstruct.PDF, field *fieldNode, value string, fontRef pdfstruct.Reference, size float64) (err error) {
+	var (
+		bbox  []float64
+		bboxa pdfstruct.Array
+	)
+	if bbox, bboxa, err = textBBox(pdf, field.ref, field.dict); err != nil {
+		return err
+	}
+	var quadding, _ = field.inherited("Q").(int)
+	var cstream = textCStream(bbox, value, "Helv", "Helvetica", size, quadding)
+	if err = textAPN(pdf, field.ref, field.dict, bboxa, value, "Helv", fontRef, cstream); err != nil {
+		return err
+	}
+	field.update(pdf)
+	return nil
+}
+
+// zapfDingbats returns a reference to the font dictionary for /ZaDb in the
+// form's default resources, adding a standard ZapfDingbats font there if
+// /ZaDb isn't already defined.
+func zapfDingbats(pdf *pdfstruct.PDF, form pdfstruct.Dict) (ref pdfstruct.Reference, err error) {
+	return formFont(pdf, form, "ZaDb", pdfstruct.Dict{
+		"Type":     pdfstruct.Name("Font"),
+		"Subtype":  pdfstruct.Name("Type1"),
+		"BaseFont": pdfstruct.Name("ZapfDingbats"),
+	})
+}
+
+// A dingbat is a ZapfDingbats glyph used as the mark in a checkbox or radio
+// button, with its width and vertical extent in thousandths of an em.
+type dingbat struct {
+	code          byte
+	width         float64
+	bottom, top   float64
+	round         bool // button is drawn as a circle rather than a square
+	sizeToButtons float64
+}
+
+var (
+	checkGlyph = dingbat{code: '4', width: 846, bottom: -14, top: 705, sizeToButtons: 0.8}
+	radioGlyph = dingbat{code: 'l', width: 791, bottom: -14, top: 708, round: true, sizeToButtons: 0.5}
+)
+
+// buttonAppearance returns an appearance dictionary for a checkbox or radio
+// button with the specified rectangle.  Its "Off" state shows an empty box (or
+// circle); its on state adds the glyph in the middle.
+func buttonAppearance(pdf *pdfstruct.PDF, rect [4]float64, on string, glyph dingbat, fontRef pdfstruct.Reference) pdfstruct.Dict {
+	var (
+		off  bytes.Buffer
+		mark bytes.Buffer
+		w, h = rect[2] - rect[0], rect[3] - rect[1]
+		size = min(w, h) * glyph.sizeToButtons
+	)
+	off.WriteString("q 0 G 1 w ")
+	if glyph.round {
+		// Draw a circle with four Bézier curves.
+		var cx, cy, r = w / 2, h / 2, min(w, h)/2 - 0.5
+		var k = 0.5523 * r
+		fmt.Fprintf(&off, "%f %f m ", cx+r, cy)
+		fmt.Fprintf(&off, "%f %f %f %f %f %f c ", cx+r, cy+k, cx+k, cy+r, cx, cy+r)
+		fmt.Fprintf(&off, "%f %f %f %f %f %f c ", cx-k, cy+r, cx-r, cy+k, cx-r, cy)
+		fmt.Fprintf(&off, "%f %f %f %f %f %f c ", cx-r, cy-k, cx-k, cy-r, cx, cy-r)
+		fmt.Fprintf(&off, "%f %f %f %f %f %f c ", cx+k, cy-r, cx+r, cy-k, cx+r, cy)
+		off.WriteString("S Q\n")
+	} else {
+		fmt.Fprintf(&off, "0.5 0.5 %f %f re S Q\n", w-1, h-1)
+	}
+	mark.Write(off.Bytes())
+	fmt.Fprintf(&mark, "BT /ZaDb %f Tf 0 g %f %f Td (%c) Tj ET\n", size,
+		(w-glyph.width*size/1000)/2, (h-(glyph.top-glyph.bottom)*size/1000)/2-glyph.bottom*size/1000, glyph.code)
+	return pdfstruct.Dict{"N": pdfstruct.Dict{
+		pdfstruct.Name(on): pdf.CreateObject(appearanceStream(w, h, "ZaDb", fontRef, mark.Bytes())),
+		"Off":              pdf.CreateObject(appearanceStream(w, h, "", fontRef, off.Bytes())),
+	}}
+}
+
+// appearanceStream returns an appearance stream of the specified size, with
+// the specified content, using the specified font (if fontName is not empty).
+func appearanceStream(w, h float64, fontName string, fontRef pdfstruct.Reference, content []byte) pdfstruct.Stream {
+	var res = pdfstruct.Dict{"ProcSet": pdfstruct.Array{pdfstruct.Name("PDF")}}
+	if fontName != "" {
+		res["Font"] = pdfstruct.Dict{pdfstruct.Name(fontName): fontRef}
+		res["ProcSet"] = pdfstruct.Array{pdfstruct.Name("PDF"), pdfstruct.Name("Text")}
+	}
+	return pdfstruct.Stream{
+		Dict: pdfstruct.Dict{
+			"Type":      pdfstruct.Name("XObject"),
+			"Subtype":   pdfstruct.Name("Form"),
+			"BBox":      pdfstruct.Array{0.0, 0.0, w, h},
+			"Resources": res,
+		},
+		Data: content,
+	}
+}
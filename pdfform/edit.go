@@ -0,0 +1,340 @@
+package pdfform
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// fieldKeys are the entries of a field dictionary that belong to the field
+// rather than to its widget annotation, when the two are merged into a single
+// dictionary.  The additional actions (AA) are shared between them; see
+// fieldActions.
+var fieldKeys = []pdfstruct.Name{
+	"FT", "Parent", "Kids", "T", "TU", "TM", "Ff", "V", "DV", "DA", "Q", "DS", "RV", "Opt", "TI", "I", "MaxLen",
+	"Lock", "SV",
+}
+
+// fieldActions are the triggers in an additional actions dictionary (AA) that
+// belong to the field.  The others (E, X, D, U, Fo, Bl, PO, PC, PV, PI) belong
+// to the widget annotation.
+var fieldActions = []pdfstruct.Name{"K", "F", "V", "C"}
+
+// inheritableKeys are the entries of a field dictionary that are inherited by
+// its descendants.
+var inheritableKeys = []pdfstruct.Name{"FT", "Ff", "V", "DV", "DA", "Q"}
+
+// RenameField changes the fully qualified name of a field (terminal or not)
+// from oldName to newName.  If only the last component of the name changes,
+// the field's partial name is changed in place.  Otherwise, the field is moved
+// to its new parent in the field hierarchy, creating non-terminal fields as
+// needed; it keeps the values it used to inherit from its old parent.  The
+// result is not applied until p.Write is called.
+func RenameField(pdf *pdfstruct.PDF, oldName, newName string) (err error) {
+	var (
+		form    pdfstruct.Dict
+		fields  []*fieldNode
+		field   *fieldNode
+		pages   []*pageNode
+		parent  *fieldNode
+		partial string
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return errors.New("PDF does not have any form fields")
+	}
+	if field = findField(fields, oldName); field == nil {
+		return errors.New("no such field in form")
+	}
+	if oldName == newName {
+		return nil
+	}
+	if findField(fields, newName) != nil {
+		return fmt.Errorf("field %q already exists", newName)
+	}
+	if strings.HasPrefix(newName, oldName+".") {
+		return fmt.Errorf("field %q cannot be moved inside itself", oldName)
+	}
+	oldParent, _, _ := cutLast(oldName)
+	newParent, last, _ := cutLast(newName)
+	if last == "" {
+		return fmt.Errorf("field name %q is not valid", newName)
+	}
+	if oldParent == newParent {
+		field.dict["T"] = encodeText(last)
+		field.update(pdf)
+		return nil
+	}
+	if field.ref.Number == 0 {
+		return fmt.Errorf("field %q is not a Reference", oldName)
+	}
+	if pages, err = getPages(pdf); err != nil {
+		return err
+	}
+	// Find (or create) the new parent before detaching the field from the
+	// old one, so that a shared ancestor isn't removed for lack of kids.
+	if parent, partial, err = fieldParent(pdf, form, fields, newName); err != nil {
+		return err
+	}
+	for _, key := range inheritableKeys {
+		if _, ok := field.dict[key]; !ok {
+			if v := field.inherited(key); v != nil {
+				field.dict[key] = v
+			}
+		}
+	}
+	if err = detachField(pdf, form, pages, field); err != nil {
+		return err
+	}
+	field.dict["T"] = encodeText(partial)
+	if parent != nil {
+		field.dict["Parent"] = parent.ref
+	} else {
+		delete(field.dict, "Parent")
+	}
+	field.parent = parent
+	pdf.UpdateObject(field.ref, field.dict)
+	return addField(pdf, form, parent, field)
+}
+
+// cutLast splits a fully qualified field name into the name of its parent and
+// its partial name.  found is false if the field has no parent.
+func cutLast(name string) (parent, last string, found bool) {
+	if idx := strings.LastIndexByte(name, '.'); idx >= 0 {
+		return name[:idx], name[idx+1:], true
+	}
+	return "", name, false
+}
+
+// DeleteField removes a field (terminal or not) from the form.  The field and
+// its descendants are removed from the field hierarchy and from
+// AcroForm[CO], and their widgets are removed from their pages.  The result is
+// not applied until p.Write is called.
+func DeleteField(pdf *pdfstruct.PDF, name string) (err error) {
+	var (
+		form   pdfstruct.Dict
+		fields []*fieldNode
+		field  *fieldNode
+		pages  []*pageNode
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return errors.New("PDF does not have any form fields")
+	}
+	if field = findField(fields, name); field == nil {
+		return errors.New("no such field in form")
+	}
+	if pages, err = getPages(pdf); err != nil {
+		return err
+	}
+	return removeField(pdf, form, pages, field)
+}
+
+// MergeFields merges the source field into the target field.  Both must be
+// terminal fields of the same type.  The widgets of the source field become
+// widgets of the target field, so they show the target field's value, and
+// the source field is removed from the form.  The widgets keep their current
+// appearances until the field value is next changed.  The result is not
+// applied until p.Write is called.
+func MergeFields(pdf *pdfstruct.PDF, target, source string) (err error) {
+	var (
+		form   pdfstruct.Dict
+		fields []*fieldNode
+		tfield *fieldNode
+		sfield *fieldNode
+		pages  []*pageNode
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return errors.New("PDF does not have any form fields")
+	}
+	if tfield = findField(fields, target); tfield == nil {
+		return fmt.Errorf("no such field %q in form", target)
+	}
+	if sfield = findField(fields, source); sfield == nil {
+		return fmt.Errorf("no such field %q in form", source)
+	}
+	if tfield == sfield {
+		return errors.New("cannot merge a field with itself")
+	}
+	for _, f := range []*fieldNode{tfield, sfield} {
+		if !f.terminal() {
+			return fmt.Errorf("%q is not a terminal field", f.name)
+		}
+		if f.ref.Number == 0 {
+			return fmt.Errorf("field %q is not a Reference", f.name)
+		}
+		for _, w := range f.widgets {
+			if w.ref.Number == 0 {
+				return fmt.Errorf("widget of field %q is not a Reference", f.name)
+			}
+		}
+	}
+	if tfield.fieldType() != sfield.fieldType() {
+		return errors.New("fields have different types")
+	}
+	if pages, err = getPages(pdf); err != nil {
+		return err
+	}
+	// If the target field is merged with its widget, separate them so that
+	// the field can have more widgets.
+	if len(tfield.widgets) == 1 && tfield.widgets[0] == tfield {
+		if tfield, err = separateWidget(pdf, form, tfield); err != nil {
+			return err
+		}
+	}
+	// Detach the source field from the form, leaving its widgets on their
+	// pages.
+	if err = removeRefs(pdf, form, "CO", map[pdfstruct.Reference]bool{sfield.ref: true}, func() error {
+		return updateForm(pdf, form)
+	}); err != nil {
+		return fmt.Errorf("AcroForm[CO]: %s", err)
+	}
+	if err = detachField(pdf, form, pages, sfield); err != nil {
+		return err
+	}
+	// Make its widgets into widgets of the target field.
+	for _, w := range sfield.widgets {
+		if w == sfield {
+			for _, key := range fieldKeys {
+				delete(w.dict, key)
+			}
+			if _, err = splitActions(pdf, w); err != nil {
+				return err
+			}
+		}
+		w.dict["Parent"] = tfield.ref
+		pdf.UpdateObject(w.ref, w.dict)
+		if err = appendRef(pdf, tfield.dict, "Kids", w.ref, func() error { tfield.update(pdf); return nil }); err != nil {
+			return fmt.Errorf("%s[Kids]: %s", tfield.name, err)
+		}
+	}
+	return nil
+}
+
+// separateWidget splits a terminal field that is merged with its only widget
+// into a field with a separate widget.  The existing dictionary stays in place
+// as the widget, so that page annotation lists don't change; a new field
+// dictionary takes its place in the field hierarchy.  It returns the new field.
+func separateWidget(pdf *pdfstruct.PDF, form pdfstruct.Dict, widget *fieldNode) (field *fieldNode, err error) {
+	var actions pdfstruct.Dict
+
+	field = &fieldNode{dict: make(pdfstruct.Dict), name: widget.name, parent: widget.parent}
+	for _, key := range fieldKeys {
+		if v, ok := widget.dict[key]; ok {
+			field.dict[key] = v
+			delete(widget.dict, key)
+		}
+	}
+	if actions, err = splitActions(pdf, widget); err != nil {
+		return nil, err
+	}
+	if actions != nil {
+		field.dict["AA"] = actions
+	}
+	field.dict["Kids"] = pdfstruct.Array{widget.ref}
+	field.ref = pdf.CreateObject(field.dict)
+	field.widgets = []*fieldNode{widget}
+	widget.dict["Parent"] = field.ref
+	widget.parent = field
+	pdf.UpdateObject(widget.ref, widget.dict)
+	if err = replaceRef(pdf, form, "CO", widget.ref, field.ref, func() error { return updateForm(pdf, form) }); err != nil {
+		return nil, fmt.Errorf("AcroForm[CO]: %s", err)
+	}
+	if field.parent == nil {
+		if err = replaceRef(pdf, form, "Fields", widget.ref, field.ref, func() error { return updateForm(pdf, form) }); err != nil {
+			return nil, fmt.Errorf("AcroForm[Fields]: %s", err)
+		}
+		return field, nil
+	}
+	var parent = field.parent
+	if err = replaceRef(pdf, parent.dict, "Kids", widget.ref, field.ref, func() error { parent.update(pdf); return nil }); err != nil {
+		return nil, fmt.Errorf("%s[Kids]: %s", parent.name, err)
+	}
+	for i, k := range parent.kids {
+		if k == widget {
+			parent.kids[i] = field
+		}
+	}
+	return field, nil
+}
+
+// splitActions removes the field triggers from the additional actions of a
+// widget that is merged with its field, and returns them (or nil if there are
+// none).  The widget keeps its annotation triggers.
+func splitActions(pdf *pdfstruct.PDF, widget *fieldNode) (actions pdfstruct.Dict, err error) {
+	var (
+		aa   pdfstruct.Dict
+		rest = make(pdfstruct.Dict)
+	)
+	if aa, err = dictOf(pdf, widget.dict["AA"]); err != nil {
+		return nil, fmt.Errorf("widget[AA]: %s", err)
+	}
+	for key, action := range aa {
+		if slices.Contains(fieldActions, key) {
+			if actions == nil {
+				actions = make(pdfstruct.Dict)
+			}
+			actions[key] = action
+		} else {
+			rest[key] = action
+		}
+	}
+	if len(rest) != 0 {
+		widget.dict["AA"] = rest
+	} else {
+		delete(widget.dict, "AA")
+	}
+	return actions, nil
+}
+
+// SplitField splits a terminal field with multiple widgets into separate
+// fields, so that each widget can have its own value.  The field becomes a
+// non-terminal field, and each of its widgets becomes a terminal field whose
+// partial name is its zero-based index ("0", "1", etc.).  The new fields
+// inherit the field's current value.  Radio button groups cannot be split.
+// The result is not applied until p.Write is called.
+func SplitField(pdf *pdfstruct.PDF, name string) (err error) {
+	var (
+		fields []*fieldNode
+		field  *fieldNode
+	)
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return errors.New("PDF does not have any form fields")
+	}
+	if field = findField(fields, name); field == nil {
+		return errors.New("no such field in form")
+	}
+	if !field.terminal() {
+		return fmt.Errorf("%q is not a terminal field", name)
+	}
+	if fieldKind(field) == "radio" {
+		return errors.New("radio button groups cannot be split")
+	}
+	if len(field.widgets) < 2 {
+		return fmt.Errorf("field %q has only one widget", name)
+	}
+	for _, w := range field.widgets {
+		if w.ref.Number == 0 {
+			return fmt.Errorf("widget of field %q is not a Reference", name)
+		}
+	}
+	for i, w := range field.widgets {
+		w.dict["T"] = strconv.Itoa(i)
+		pdf.UpdateObject(w.ref, w.dict)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package pdfform
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+/*
+FDF files are encoded as follows:
+    %FDF-1.2
+    1 0 obj <<
+        /FDF << /Fields [				[hierarchy mirrors AcroForm[Fields]]
+            << /T (Name) /V (John Smith) >>		[text field: string value]
+            << /T (Agree) /V /Yes >>		[button: Name value]
+            << /T (person) /Kids [			[partial names for hierarchical fields]
+                << /T (first) /V <FEFF004A00F6> >>	[UTF-16BE text strings allowed]
+            ] >>
+        ] >>
+    >> endobj
+    trailer << /Root 1 0 R >>
+    %%EOF
+
+XFDF files carry the same information in XML:
+    <xfdf xmlns="http://ns.adobe.com/xfdf/" xml:space="preserve">
+      <fields>
+        <field name="Name"><value>John Smith</value></field>
+        <field name="person"><field name="first"><value>Jö</value></field></field>
+      </fields>
+    </xfdf>
+*/
+
+// ExportFDF writes the values of all fields in the PDF to an FDF file.
+func ExportFDF(pdf *pdfstruct.PDF, wr io.Writer) (err error) {
+	var fields []*fieldNode
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	return pdfstruct.WriteFDF(wr, pdfstruct.Dict{
+		"FDF": pdfstruct.Dict{"Fields": fdfFields(fields)},
+	})
+}
+
+// fdfFields returns the FDF field list for the supplied fields.
+func fdfFields(fields []*fieldNode) (list pdfstruct.Array) {
+	list = pdfstruct.Array{}
+	for _, f := range fields {
+		var fd = make(pdfstruct.Dict)
+		if t, ok := f.dict["T"].(string); ok {
+			fd["T"] = t
+		}
+		if !f.terminal() {
+			fd["Kids"] = fdfFields(f.kids)
+		} else if v := fieldValue(f); v != nil {
+			fd["V"] = v
+		}
+		list = append(list, fd)
+	}
+	return list
+}
+
+// fieldValue returns the value of a terminal field: a string (as a PDF text
+// string) for text and choice fields, a Name for buttons, an Array of strings
+// for multiple-selection choice fields, or nil if the field has no value.
+func fieldValue(f *fieldNode) pdfstruct.Object {
+	switch v := f.inherited("V").(type) {
+	case string, pdfstruct.Name:
+		return v
+	case pdfstruct.Array:
+		var list pdfstruct.Array
+		for _, o := range v {
+			if s, ok := o.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+// ImportFDF reads an FDF file and applies the field values in it to the PDF,
+// using SetFields.  Multiple selections in list boxes are not supported; a
+// field with more than one value is an error.  The result is not applied until
+// p.Write is called.
+func ImportFDF(pdf *pdfstruct.PDF, r io.Reader, opts Options) (err error) {
+	var (
+		fdf    *pdfstruct.FDF
+		fdfd   pdfstruct.Dict
+		fields pdfstruct.Array
+		values []FieldValue
+	)
+	if fdf, err = pdfstruct.ReadFDF(r); err != nil {
+		return err
+	}
+	if fdfd, err = fdfDict(fdf, fdf.Catalog["FDF"]); err != nil {
+		return fmt.Errorf("FDF catalog[FDF]: %s", err)
+	}
+	if fdfd == nil {
+		return errors.New("FDF catalog has no FDF dictionary")
+	}
+	if fields, err = fdfArray(fdf, fdfd["Fields"]); err != nil {
+		return fmt.Errorf("FDF[Fields]: %s", err)
+	}
+	if values, err = importFDFFields(fdf, fields, "", values); err != nil {
+		return err
+	}
+	return SetFields(pdf, values, opts)
+}
+
+// importFDFFields appends the values in a list of FDF fields to values.
+func importFDFFields(
+	fdf *pdfstruct.FDF, fields pdfstruct.Array, prefix string, values []FieldValue,
+) (_ []FieldValue, err error) {
+	for i, f := range fields {
+		var (
+			fd    pdfstruct.Dict
+			name  = prefix
+			kids  pdfstruct.Array
+			value string
+		)
+		if fd, err = fdfDict(fdf, f); err != nil {
+			return nil, fmt.Errorf("FDF field %s[%d]: %s", prefix, i, err)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("FDF field %s[%d] is not a Dict", prefix, i)
+		}
+		if t, ok := fd["T"].(string); ok {
+			if name != "" {
+				name += "."
+			}
+			name += decodeText(t)
+		}
+		if kids, err = fdfArray(fdf, fd["Kids"]); err != nil {
+			return nil, fmt.Errorf("%s[Kids]: %s", name, err)
+		}
+		if len(kids) != 0 {
+			if values, err = importFDFFields(fdf, kids, name, values); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var v = fd["V"]
+		if r, ok := v.(pdfstruct.Reference); ok {
+			if v, err = fdf.Get(r); err != nil {
+				return nil, fmt.Errorf("%s[V]: %s", name, err)
+			}
+		}
+		if a, ok := v.(pdfstruct.Array); ok && len(a) != 0 {
+			// We don't support multiple selections.
+			if len(a) > 1 {
+				return nil, fmt.Errorf("%s: multiple selections are not supported", name)
+			}
+			v = a[0]
+		}
+		switch v := v.(type) {
+		case string:
+			value = decodeText(v)
+		case pdfstruct.Name:
+			value = string(v)
+		default:
+			continue
+		}
+		values = append(values, FieldValue{Name: name, Value: value})
+	}
+	return values, nil
+}
+
+// fdfDict returns the Dict that obj is or refers to in the FDF.
+func fdfDict(fdf *pdfstruct.FDF, obj pdfstruct.Object) (dict pdfstruct.Dict, err error) {
+	if r, ok := obj.(pdfstruct.Reference); ok {
+		if obj, err = fdf.Get(r); err != nil {
+			return nil, err
+		}
+	}
+	switch o := obj.(type) {
+	case nil:
+		return nil, nil
+	case pdfstruct.Dict:
+		return o, nil
+	default:
+		return nil, errors.New("not a Dict")
+	}
+}
+
+// fdfArray returns the Array that obj is or refers to in the FDF.
+func fdfArray(fdf *pdfstruct.FDF, obj pdfstruct.Object) (array pdfstruct.Array, err error) {
+	if r, ok := obj.(pdfstruct.Reference); ok {
+		if obj, err = fdf.Get(r); err != nil {
+			return nil, err
+		}
+	}
+	switch o := obj.(type) {
+	case nil:
+		return nil, nil
+	case pdfstruct.Array:
+		return o, nil
+	default:
+		return nil, errors.New("not an Array")
+	}
+}
+
+// xfdfField is a field in an XFDF file.
+type xfdfField struct {
+	XMLName xml.Name    `xml:"field"`
+	Name    string      `xml:"name,attr"`
+	Values  []string    `xml:"value"`
+	Fields  []xfdfField `xml:"field"`
+}
+
+// xfdfDoc is an XFDF file.
+type xfdfDoc struct {
+	XMLName xml.Name    `xml:"xfdf"`
+	Fields  []xfdfField `xml:"fields>field"`
+}
+
+// ExportXFDF writes the values of all fields in the PDF to an XFDF file.
+func ExportXFDF(pdf *pdfstruct.PDF, wr io.Writer) (err error) {
+	var (
+		fields []*fieldNode
+		enc    *xml.Encoder
+	)
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(wr, xml.Header+`<xfdf xmlns="http://ns.adobe.com/xfdf/" xml:space="preserve">`+"\n<fields>\n"); err != nil {
+		return err
+	}
+	enc = xml.NewEncoder(wr)
+	enc.Indent("", " ")
+	for _, xf := range xfdfFields(fields) {
+		if err = enc.Encode(xf); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(wr, "\n</fields>\n</xfdf>\n")
+	return err
+}
+
+// xfdfFields returns the XFDF field list for the supplied fields.
+func xfdfFields(fields []*fieldNode) (list []xfdfField) {
+	for _, f := range fields {
+		var xf xfdfField
+		if t, ok := f.dict["T"].(string); ok {
+			xf.Name = decodeText(t)
+		}
+		if !f.terminal() {
+			xf.Fields = xfdfFields(f.kids)
+		} else {
+			switch v := fieldValue(f).(type) {
+			case string:
+				xf.Values = []string{decodeText(v)}
+			case pdfstruct.Name:
+				xf.Values = []string{string(v)}
+			case pdfstruct.Array:
+				for _, s := range v {
+					xf.Values = append(xf.Values, decodeText(s.(string)))
+				}
+			}
+		}
+		list = append(list, xf)
+	}
+	return list
+}
+
+// ImportXFDF reads an XFDF file and applies the field values in it to the PDF,
+// using SetFields.  Multiple selections in list boxes are not supported; a
+// field with more than one value is an error.  The result is not applied until
+// p.Write is called.
+func ImportXFDF(pdf *pdfstruct.PDF, r io.Reader, opts Options) (err error) {
+	var (
+		doc    xfdfDoc
+		values []FieldValue
+	)
+	if err = xml.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("reading XFDF: %s", err)
+	}
+	if values, err = importXFDFFields(doc.Fields, "", values); err != nil {
+		return err
+	}
+	return SetFields(pdf, values, opts)
+}
+
+// importXFDFFields appends the values in a list of XFDF fields to values.
+func importXFDFFields(fields []xfdfField, prefix string, values []FieldValue) (_ []FieldValue, err error) {
+	for _, xf := range fields {
+		var name = xf.Name
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if len(xf.Fields) != 0 {
+			if values, err = importXFDFFields(xf.Fields, name, values); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch len(xf.Values) {
+		case 0:
+			continue
+		case 1:
+			values = append(values, FieldValue{Name: name, Value: xf.Values[0]})
+		default:
+			// We don't support multiple selections.
+			return nil, fmt.Errorf("%s: multiple selections are not supported", name)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,385 @@
+package pdfform
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// Annotation flags (the F entry of a widget).
+const (
+	annotHidden = 1 << 1
+	annotPrint  = 1 << 2
+)
+
+// Flatten converts form fields into static page content, so that they can no
+// longer be edited.  The normal appearance of each widget of each flattened
+// field is drawn onto the widget's page, and the fields and their widgets are
+// removed from the form.  Widgets that are hidden are removed without being
+// drawn.  Widgets that are visible but not marked for printing (including
+// widgets with no flags at all, which many form generators produce) are drawn
+// as optional content that is shown on screen but not printed.
+//
+// names is the list of fully qualified names of the fields to be flattened;
+// naming a non-terminal field flattens all of its descendants.  If no names
+// are given, all fields are flattened.  The result is not applied until p.Write
+// is called.
+func Flatten(pdf *pdfstruct.PDF, names ...string) (err error) {
+	var (
+		form     pdfstruct.Dict
+		fields   []*fieldNode
+		pages    []*pageNode
+		index    map[pdfstruct.Reference]*pageNode
+		selected []*fieldNode
+		draw     = make(map[*pageNode]*bytes.Buffer)
+		noPrint  pdfstruct.Reference
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if form == nil {
+		return nil
+	}
+	if pages, err = getPages(pdf); err != nil {
+		return err
+	}
+	if index, err = indexAnnots(pdf, pages); err != nil {
+		return err
+	}
+	walkFields(fields, func(f *fieldNode) error {
+		if matchName(f.name, names) {
+			selected = append(selected, f)
+		}
+		return nil
+	})
+	// Draw the appearances of the selected fields onto their pages.
+	for _, f := range selected {
+		for _, w := range f.widgets {
+			var page *pageNode
+			if page = widgetPage(pages, index, w); page == nil {
+				continue
+			}
+			if err = flattenWidget(pdf, page, w, draw, &noPrint); err != nil {
+				return fmt.Errorf("%s: %s", f.name, err)
+			}
+		}
+	}
+	for _, page := range pages {
+		if buf := draw[page]; buf != nil {
+			if err = flattenContents(pdf, page, buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	// Remove the selected fields from the form.
+	for _, f := range selected {
+		if err = removeField(pdf, form, pages, f); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+	}
+	return nil
+}
+
+// flattenWidget adds the drawing instructions for a widget's appearance to the
+// buffer for its page.  noPrint is the optional content group for widgets that
+// aren't printed; flattenWidget creates it when it is first needed.
+func flattenWidget(
+	pdf *pdfstruct.PDF, page *pageNode, widget *fieldNode, draw map[*pageNode]*bytes.Buffer, noPrint *pdfstruct.Reference,
+) (err error) {
+	var (
+		apref  pdfstruct.Reference
+		ap     pdfstruct.Stream
+		found  bool
+		rect   []float64
+		bbox   []float64
+		matrix = []float64{1, 0, 0, 1, 0, 0}
+		name   pdfstruct.Name
+		oc     pdfstruct.Name
+	)
+	flags, _ := widget.dict["F"].(int)
+	if flags&annotHidden != 0 {
+		return nil
+	}
+	if apref, ap, found, err = widgetAppearance(pdf, widget); err != nil || !found {
+		return err
+	}
+	if rect, err = rectOf(pdf, widget.dict["Rect"]); err != nil {
+		return fmt.Errorf("widget[Rect]: %s", err)
+	}
+	if bbox, err = rectOf(pdf, ap.Dict["BBox"]); err != nil {
+		return fmt.Errorf("widget[AP][N][BBox]: %s", err)
+	}
+	if m, err := arrayOf(pdf, ap.Dict["Matrix"]); err == nil && len(m) == 6 {
+		for i := range m {
+			if matrix[i], err = numberOf(pdf, m[i]); err != nil {
+				return errors.New("widget[AP][N][Matrix] is not an Array of 6 numbers")
+			}
+		}
+	}
+	// Make sure the appearance stream is marked as a form XObject, which is
+	// implied for appearance streams but required for Do.
+	if ap.Dict["Subtype"] != pdfstruct.Name("Form") {
+		ap.Dict["Type"] = pdfstruct.Name("XObject")
+		ap.Dict["Subtype"] = pdfstruct.Name("Form")
+		pdf.UpdateObject(apref, ap)
+	}
+	if name, err = addPageResource(pdf, page, "XObject", apref, "Flat"); err != nil {
+		return err
+	}
+	var cm, ok = formMatrix(bbox, matrix, rect)
+	if !ok {
+		return nil
+	}
+	if flags&annotPrint == 0 {
+		if noPrint.Number == 0 {
+			if *noPrint, err = noPrintGroup(pdf); err != nil {
+				return err
+			}
+		}
+		if oc, err = addPageResource(pdf, page, "Properties", *noPrint, "FlatOC"); err != nil {
+			return err
+		}
+	}
+	if draw[page] == nil {
+		draw[page] = new(bytes.Buffer)
+	}
+	if oc != "" {
+		fmt.Fprintf(draw[page], "/OC /%s BDC ", oc)
+	}
+	fmt.Fprintf(draw[page], "q %f %f %f %f %f %f cm /%s Do Q", cm[0], cm[1], cm[2], cm[3], cm[4], cm[5], name)
+	if oc != "" {
+		draw[page].WriteString(" EMC")
+	}
+	draw[page].WriteByte('\n')
+	return nil
+}
+
+// noPrintGroup creates an optional content group that is visible on screen but
+// not printed, and adds it to the document's optional content properties.
+func noPrintGroup(pdf *pdfstruct.PDF) (ocg pdfstruct.Reference, err error) {
+	var (
+		props pdfstruct.Dict
+		ocgs  pdfstruct.Array
+		d     pdfstruct.Dict
+		as    pdfstruct.Array
+	)
+	ocg = pdf.CreateObject(pdfstruct.Dict{
+		"Type": pdfstruct.Name("OCG"),
+		"Name": "Form fields not printed",
+		"Usage": pdfstruct.Dict{
+			"View":  pdfstruct.Dict{"ViewState": pdfstruct.Name("ON")},
+			"Print": pdfstruct.Dict{"PrintState": pdfstruct.Name("OFF")},
+		},
+	})
+	// The catalog gets a new OCProperties dictionary with the group added
+	// to its OCGs, and with an auto-state entry in its default
+	// configuration so that viewers apply the group's usage when printing.
+	if props, err = dictOf(pdf, pdf.Catalog["OCProperties"]); err != nil {
+		return ocg, fmt.Errorf("Root[OCProperties]: %s", err)
+	}
+	if ocgs, err = arrayOf(pdf, props["OCGs"]); err != nil {
+		return ocg, fmt.Errorf("Root[OCProperties][OCGs]: %s", err)
+	}
+	if d, err = dictOf(pdf, props["D"]); err != nil {
+		return ocg, fmt.Errorf("Root[OCProperties][D]: %s", err)
+	}
+	if as, err = arrayOf(pdf, d["AS"]); err != nil {
+		return ocg, fmt.Errorf("Root[OCProperties][D][AS]: %s", err)
+	}
+	if props = maps.Clone(props); props == nil {
+		props = make(pdfstruct.Dict)
+	}
+	if d = maps.Clone(d); d == nil {
+		d = make(pdfstruct.Dict)
+	}
+	props["OCGs"] = append(ocgs[:len(ocgs):len(ocgs)], ocg)
+	d["AS"] = append(as[:len(as):len(as)], pdfstruct.Dict{
+		"Event":    pdfstruct.Name("Print"),
+		"OCGs":     pdfstruct.Array{ocg},
+		"Category": pdfstruct.Array{pdfstruct.Name("Print")},
+	})
+	props["D"] = d
+	pdf.Catalog["OCProperties"] = props
+	return ocg, updateCatalog(pdf)
+}
+
+// widgetAppearance returns the normal appearance stream of a widget, in the
+// widget's current appearance state.  It returns found=false if the widget
+// doesn't have one.
+func widgetAppearance(
+	pdf *pdfstruct.PDF, widget *fieldNode,
+) (ref pdfstruct.Reference, stream pdfstruct.Stream, found bool, err error) {
+	var (
+		ap  pdfstruct.Dict
+		obj pdfstruct.Object
+	)
+	if ap, err = dictOf(pdf, widget.dict["AP"]); err != nil {
+		return ref, stream, false, fmt.Errorf("widget[AP]: %s", err)
+	}
+	obj = ap["N"]
+	if r, ok := obj.(pdfstruct.Reference); ok {
+		if obj, err = pdf.Get(r); err != nil {
+			return ref, stream, false, fmt.Errorf("widget[AP][N]: %s", err)
+		}
+		if stream, ok = obj.(pdfstruct.Stream); ok {
+			return r, stream, true, nil
+		}
+	}
+	if states, ok := obj.(pdfstruct.Dict); ok {
+		// The appearance has multiple states; use the current one.
+		as, _ := widget.dict["AS"].(pdfstruct.Name)
+		if obj = states[as]; obj == nil {
+			return ref, stream, false, nil
+		}
+		if r, ok := obj.(pdfstruct.Reference); ok {
+			if stream, err = pdf.GetStream(r); err != nil {
+				return ref, stream, false, fmt.Errorf("widget[AP][N][%s]: %s", as, err)
+			}
+			return r, stream, true, nil
+		}
+	}
+	switch o := obj.(type) {
+	case nil:
+		return ref, stream, false, nil
+	case pdfstruct.Stream:
+		// Appearance streams should always be separate objects, but
+		// if this one isn't, we need to make it one.
+		return pdf.CreateObject(o), o, true, nil
+	default:
+		return ref, stream, false, errors.New("widget[AP][N] is not a Stream or Dict")
+	}
+}
+
+// formMatrix returns the transformation matrix that maps a form XObject, with
+// the specified bounding box and matrix, onto the specified annotation
+// rectangle, following the algorithm in section 12.5.5 of the PDF
+// specification.  It returns false if the appearance is degenerate.
+func formMatrix(bbox, matrix, rect []float64) (cm [6]float64, ok bool) {
+	var xmin, ymin, xmax, ymax = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, pt := range [][2]float64{{bbox[0], bbox[1]}, {bbox[0], bbox[3]}, {bbox[2], bbox[1]}, {bbox[2], bbox[3]}} {
+		x := matrix[0]*pt[0] + matrix[2]*pt[1] + matrix[4]
+		y := matrix[1]*pt[0] + matrix[3]*pt[1] + matrix[5]
+		xmin, xmax = min(xmin, x), max(xmax, x)
+		ymin, ymax = min(ymin, y), max(ymax, y)
+	}
+	if xmax-xmin == 0 || ymax-ymin == 0 {
+		return cm, false
+	}
+	var rx0, ry0 = min(rect[0], rect[2]), min(rect[1], rect[3])
+	var sx = (max(rect[0], rect[2]) - rx0) / (xmax - xmin)
+	var sy = (max(rect[1], rect[3]) - ry0) / (ymax - ymin)
+	return [6]float64{sx, 0, 0, sy, rx0 - sx*xmin, ry0 - sy*ymin}, true
+}
+
+// addPageResource adds a resource of the specified category (XObject or
+// Properties) to the resources of the page, and returns the name it was given,
+// which starts with prefix.
+func addPageResource(
+	pdf *pdfstruct.PDF, page *pageNode, category pdfstruct.Name, ref pdfstruct.Reference, prefix string,
+) (name pdfstruct.Name, err error) {
+	var (
+		resobj pdfstruct.Object
+		res    pdfstruct.Dict
+		resref pdfstruct.Reference
+		cat    pdfstruct.Dict
+		catref pdfstruct.Reference
+	)
+	if resobj, err = page.inherited(pdf, "Resources"); err != nil {
+		return "", fmt.Errorf("page[Resources]: %s", err)
+	}
+	switch r := resobj.(type) {
+	case nil:
+		res = make(pdfstruct.Dict)
+		page.dict["Resources"] = res
+	case pdfstruct.Reference:
+		if res, err = pdf.GetDict(r); err != nil {
+			return "", fmt.Errorf("page[Resources]: %s", err)
+		}
+		resref = r
+	case pdfstruct.Dict:
+		if _, ok := page.dict["Resources"]; ok {
+			res = r
+		} else {
+			// The resources are inherited; the page gets its own
+			// copy so that we don't change other pages.
+			res = make(pdfstruct.Dict, len(r)+1)
+			for k, v := range r {
+				res[k] = v
+			}
+			page.dict["Resources"] = res
+		}
+	default:
+		return "", errors.New("page[Resources] is not a Dict")
+	}
+	switch c := res[category].(type) {
+	case nil:
+		cat = make(pdfstruct.Dict)
+		res[category] = cat
+	case pdfstruct.Reference:
+		if cat, err = pdf.GetDict(c); err != nil {
+			return "", fmt.Errorf("page[Resources][%s]: %s", category, err)
+		}
+		catref = c
+	case pdfstruct.Dict:
+		cat = c
+	default:
+		return "", fmt.Errorf("page[Resources][%s] is not a Dict", category)
+	}
+	for n, r := range cat {
+		if r == ref {
+			return n, nil
+		}
+	}
+	for i := 1; ; i++ {
+		name = pdfstruct.Name(fmt.Sprintf("%s%d", prefix, i))
+		if _, ok := cat[name]; !ok {
+			break
+		}
+	}
+	cat[name] = ref
+	switch {
+	case catref.Number != 0:
+		pdf.UpdateObject(catref, cat)
+	case resref.Number != 0:
+		pdf.UpdateObject(resref, res)
+	default:
+		pdf.UpdateObject(page.ref, page.dict)
+	}
+	return name, nil
+}
+
+// flattenContents adds drawing instructions to the end of the contents of the
+// page.  The existing contents are wrapped in a save/restore of the graphics
+// state so that they can't affect the added instructions.
+func flattenContents(pdf *pdfstruct.PDF, page *pageNode, data []byte) (err error) {
+	var contents pdfstruct.Array
+
+	contents = append(contents, pdf.CreateObject(pdfstruct.Stream{Dict: make(pdfstruct.Dict), Data: []byte("q\n")}))
+	switch c := page.dict["Contents"].(type) {
+	case nil:
+		break
+	case pdfstruct.Reference:
+		var obj pdfstruct.Object
+		if obj, err = pdf.Get(c); err != nil {
+			return fmt.Errorf("page[Contents]: %s", err)
+		}
+		if a, ok := obj.(pdfstruct.Array); ok {
+			contents = append(contents, a...)
+		} else {
+			contents = append(contents, c)
+		}
+	case pdfstruct.Array:
+		contents = append(contents, c...)
+	default:
+		return errors.New("page[Contents] is not a Stream or Array")
+	}
+	data = append([]byte("Q\n"), data...)
+	contents = append(contents, pdf.CreateObject(pdfstruct.Stream{Dict: make(pdfstruct.Dict), Data: data}))
+	page.dict["Contents"] = contents
+	pdf.UpdateObject(page.ref, page.dict)
+	return nil
+}
@@ -0,0 +1,375 @@
+package pdfform
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+/*
+Acrobat forms attach JavaScript actions to fields in the field's additional
+actions dictionary:
+    /AA = Dict<<
+        /K = Dict<<			[keystroke: validates input as it is typed]
+            /S = /JavaScript
+            /JS = "AFNumber_Keystroke(2, 0, 0, 0, \"$\", true);"
+        >>
+        /F = Dict<<			[format: converts the value for display]
+            /S = /JavaScript
+            /JS = "AFNumber_Format(2, 0, 0, 0, \"$\", true);"
+        >>
+        /C = Dict<<			[calculate: computes the value from other fields]
+            /S = /JavaScript
+            /JS = "AFSimple_Calculate(\"SUM\", new Array (\"Qty1\", \"Qty2\"));"
+        >>
+    >>
+The JS can also be a Stream.  We don't run JavaScript, but we recognize calls
+to the standard Acrobat functions, which cover the vast majority of forms.
+*/
+
+// fieldScript returns the JavaScript for the specified additional action of
+// the field, or an empty string if it doesn't have one.
+func fieldScript(pdf *pdfstruct.PDF, field *fieldNode, trigger pdfstruct.Name) (script string, err error) {
+	var aa, action pdfstruct.Dict
+	if aa, err = dictOf(pdf, field.dict["AA"]); err != nil {
+		return "", fmt.Errorf("field[AA]: %s", err)
+	}
+	if action, err = dictOf(pdf, aa[trigger]); err != nil {
+		return "", fmt.Errorf("field[AA][%s]: %s", trigger, err)
+	}
+	if action["S"] != pdfstruct.Name("JavaScript") {
+		return "", nil
+	}
+	switch js := action["JS"].(type) {
+	case string:
+		return decodeText(js), nil
+	case pdfstruct.Reference:
+		var obj pdfstruct.Object
+		if obj, err = pdf.Get(js); err != nil {
+			return "", fmt.Errorf("field[AA][%s][JS]: %s", trigger, err)
+		}
+		switch o := obj.(type) {
+		case string:
+			return decodeText(o), nil
+		case pdfstruct.Stream:
+			if err = o.Decompress(0); err != nil {
+				return "", fmt.Errorf("field[AA][%s][JS]: %s", trigger, err)
+			}
+			return decodeText(string(o.Data)), nil
+		}
+	}
+	return "", nil
+}
+
+// jsStringRE matches a JavaScript string literal.
+const jsStringRE = `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`
+
+// jsString returns the value of a JavaScript string literal.
+func jsString(lit string) string {
+	if strings.HasPrefix(lit, "'") {
+		lit = `"` + strings.ReplaceAll(strings.ReplaceAll(lit[1:len(lit)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	if s, err := strconv.Unquote(lit); err == nil {
+		return s
+	}
+	return lit[1 : len(lit)-1]
+}
+
+var (
+	afNumberRE = regexp.MustCompile(`AFNumber_(Format|Keystroke)\s*\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*,\s*\d+\s*,\s*(` +
+		jsStringRE + `)\s*,\s*(true|false|1|0)\s*\)`)
+	afPercentRE = regexp.MustCompile(`AFPercent_(Format|Keystroke)\s*\(\s*(\d+)\s*,\s*(\d+)\s*(?:,\s*(true|false|1|0)\s*)?\)`)
+	afDateRE    = regexp.MustCompile(`AFDate_(FormatEx|KeystrokeEx|Format|Keystroke)\s*\(\s*(` + jsStringRE + `|\d+)\s*\)`)
+	afSpecialRE = regexp.MustCompile(`AFSpecial_(Format|Keystroke)\s*\(\s*(\d+)\s*\)`)
+)
+
+// afDateFormats are the date formats selected by number in AFDate_Format.
+var afDateFormats = []string{
+	"m/d", "m/d/yy", "mm/dd/yy", "mm/yy", "d-mmm", "d-mmm-yy", "dd-mmm-yy", "yy-mm-dd", "mmm-yy", "mmmm-yy",
+	"mmm d, yyyy", "mmmm d, yyyy", "m/d/yy h:MM tt", "m/d/yy HH:MM",
+}
+
+// textFormat applies the keystroke and format actions of a text field, if they
+// are ones we recognize, to a new value for the field.  It returns the value
+// to be stored in the field, following Acrobat's conventions, and the value to
+// be displayed.  It returns an error if the keystroke action would reject the
+// value.  Values that the format action can't parse are stored and displayed
+// unchanged, as Acrobat does.
+func textFormat(pdf *pdfstruct.PDF, field *fieldNode, value string) (stored, display string, err error) {
+	var keystroke, format string
+	if value == "" {
+		return "", "", nil
+	}
+	if keystroke, err = fieldScript(pdf, field, "K"); err != nil {
+		return "", "", err
+	}
+	if format, err = fieldScript(pdf, field, "F"); err != nil {
+		return "", "", err
+	}
+	for _, script := range []string{keystroke, format} {
+		if m := afNumberRE.FindStringSubmatch(script); m != nil {
+			sepStyle, _ := strconv.Atoi(m[3])
+			n, ok := parseNumber(value, sepStyle)
+			if !ok {
+				if m[1] == "Keystroke" {
+					return "", "", fmt.Errorf("value %q is not a valid number", value)
+				}
+				continue
+			}
+			if m[1] == "Keystroke" {
+				continue
+			}
+			stored = formatFloat(n)
+			nDec, _ := strconv.Atoi(m[2])
+			negStyle, _ := strconv.Atoi(m[4])
+			return stored, formatNumber(n, nDec, sepStyle, negStyle, jsString(m[5]), m[6] == "true" || m[6] == "1"), nil
+		}
+		if m := afPercentRE.FindStringSubmatch(script); m != nil {
+			sepStyle, _ := strconv.Atoi(m[3])
+			n, ok := parseNumber(strings.TrimSuffix(strings.TrimSpace(value), "%"), sepStyle)
+			if !ok {
+				if m[1] == "Keystroke" {
+					return "", "", fmt.Errorf("value %q is not a valid percentage", value)
+				}
+				continue
+			}
+			if strings.HasSuffix(strings.TrimSpace(value), "%") {
+				// "15%" is stored as 0.15, the same as what the
+				// user types into Acrobat to get 15%.
+				n /= 100
+			}
+			if m[1] == "Keystroke" {
+				continue
+			}
+			stored = formatFloat(n)
+			nDec, _ := strconv.Atoi(m[2])
+			if m[4] == "true" || m[4] == "1" {
+				return stored, "%" + formatNumber(n*100, nDec, sepStyle, 0, "", true), nil
+			}
+			return stored, formatNumber(n*100, nDec, sepStyle, 0, "", true) + "%", nil
+		}
+		if m := afDateRE.FindStringSubmatch(script); m != nil {
+			var layout string
+			if strings.HasSuffix(m[1], "Ex") {
+				layout = jsString(m[2])
+			} else if idx, _ := strconv.Atoi(m[2]); idx < len(afDateFormats) {
+				layout = afDateFormats[idx]
+			} else {
+				continue
+			}
+			t, ok := parseDate(value, layout)
+			if !ok {
+				if strings.HasPrefix(m[1], "Keystroke") {
+					return "", "", fmt.Errorf("value %q is not a valid date in format %q", value, layout)
+				}
+				continue
+			}
+			if strings.HasPrefix(m[1], "Keystroke") {
+				continue
+			}
+			// Acrobat stores dates in their formatted form.
+			stored = formatDate(t, layout)
+			return stored, stored, nil
+		}
+		if m := afSpecialRE.FindStringSubmatch(script); m != nil {
+			psf, _ := strconv.Atoi(m[2])
+			digits, formatted, ok := formatSpecial(value, psf)
+			if !ok {
+				if m[1] == "Keystroke" {
+					return "", "", fmt.Errorf("value %q is not valid for this field", value)
+				}
+				continue
+			}
+			if m[1] == "Keystroke" {
+				continue
+			}
+			return digits, formatted, nil
+		}
+	}
+	return value, value, nil
+}
+
+// parseNumber parses a field value as a number, the way AFNumber_Keystroke
+// accepts it.  sepStyle is as for formatNumber; styles 2 and 3 use a comma as
+// the decimal point.  Thousands separators and currency symbols are ignored.
+// A plain number, as stored in a field value, is always accepted as is.  An
+// empty value is zero.
+func parseNumber(s string, sepStyle int) (n float64, ok bool) {
+	var point = '.'
+	if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return n, true
+	}
+	if sepStyle == 2 || sepStyle == 3 {
+		point = ','
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r == '-', r == '+':
+			return r
+		case r == point:
+			return '.'
+		case r == ',', r == '.', r == ' ', r == '\'', r == '$', r == '€', r == '£', r == '¥':
+			return -1
+		default:
+			return 'x' // make it fail to parse
+		}
+	}, strings.TrimSpace(s))
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+// formatFloat formats a number for storage as a field value, with no
+// unnecessary digits.
+func formatFloat(n float64) string {
+	// Round away floating point noise such as 0.1+0.2=0.30000000000000004.
+	n = math.Round(n*1e10) / 1e10
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// formatNumber formats a number the way AFNumber_Format does.  sepStyle 0 is
+// "1,234.56", 1 is "1234.56", 2 is "1.234,56", and 3 is "1234,56".  negStyle 0
+// and 1 show negative numbers with a minus sign, and 2 and 3 in parentheses.
+// (Styles 1 and 3 also show negative numbers in red, which we don't do.)
+func formatNumber(n float64, nDec, sepStyle, negStyle int, currency string, prepend bool) string {
+	var (
+		neg   = n < 0
+		s     = strconv.FormatFloat(math.Abs(n), 'f', nDec, 64)
+		whole = s
+		frac  string
+		sep   = ","
+		point = "."
+		sb    strings.Builder
+	)
+	if idx := strings.IndexByte(s, '.'); idx >= 0 {
+		whole, frac = s[:idx], s[idx+1:]
+	}
+	switch sepStyle {
+	case 1:
+		sep = ""
+	case 2:
+		sep, point = ".", ","
+	case 3:
+		sep, point = "", ","
+	}
+	if neg && (negStyle == 0 || negStyle == 1) {
+		sb.WriteByte('-')
+	} else if neg {
+		sb.WriteByte('(')
+	}
+	if prepend {
+		sb.WriteString(currency)
+	}
+	for i, d := range whole {
+		if i != 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteRune(d)
+	}
+	if frac != "" {
+		sb.WriteString(point)
+		sb.WriteString(frac)
+	}
+	if !prepend {
+		sb.WriteString(currency)
+	}
+	if neg && negStyle != 0 && negStyle != 1 {
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+// dateLayoutTokens maps the tokens of an Acrobat date format to Go time layout
+// elements.
+var dateLayoutTokens = map[string]string{
+	"d": "2", "dd": "02", "ddd": "Mon", "dddd": "Monday",
+	"m": "1", "mm": "01", "mmm": "Jan", "mmmm": "January",
+	"yy": "06", "yyyy": "2006",
+	"H": "15", "HH": "15", "h": "3", "hh": "03",
+	"M": "4", "MM": "04", "s": "5", "ss": "05",
+	"t": "PM", "tt": "PM",
+}
+
+// dateLayout converts an Acrobat date format, such as "mm/dd/yyyy", into a Go
+// time layout.
+func dateLayout(format string) string {
+	var sb strings.Builder
+	for format != "" {
+		var n = 1
+		for n < len(format) && format[n] == format[0] {
+			n++
+		}
+		if tok, ok := dateLayoutTokens[format[:n]]; ok {
+			sb.WriteString(tok)
+		} else {
+			sb.WriteString(format[:n])
+		}
+		format = format[n:]
+	}
+	return sb.String()
+}
+
+// dateFallbackLayouts are other layouts accepted by parseDate.
+var dateFallbackLayouts = []string{
+	"2006-01-02", "1/2/2006", "1/2/06", "January 2, 2006", "Jan 2, 2006", "2 January 2006", "2 Jan 2006",
+	time.RFC3339,
+}
+
+// parseDate parses a date in the specified Acrobat date format, or in one of a
+// few other common formats.
+func parseDate(value, format string) (t time.Time, ok bool) {
+	var err error
+	value = strings.TrimSpace(value)
+	if t, err = time.Parse(dateLayout(format), value); err == nil {
+		return t, true
+	}
+	for _, layout := range dateFallbackLayouts {
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// formatDate formats a date in the specified Acrobat date format.
+func formatDate(t time.Time, format string) string {
+	return t.Format(dateLayout(format))
+}
+
+// formatSpecial formats a value the way AFSpecial_Format does.  psf 0 is a
+// ZIP code, 1 is a ZIP+4 code, 2 is a phone number, and 3 is a Social
+// Security number.  It returns the digits of the value, which is what is
+// stored, and the formatted value.  It returns false if the value doesn't
+// have the right number of digits.
+func formatSpecial(value string, psf int) (digits, formatted string, ok bool) {
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits += string(r)
+		case strings.ContainsRune(" -().", r):
+			break
+		default:
+			return "", "", false
+		}
+	}
+	switch {
+	case psf == 0 && len(digits) == 5:
+		return digits, digits, true
+	case psf == 1 && len(digits) == 9:
+		return digits, digits[:5] + "-" + digits[5:], true
+	case psf == 2 && len(digits) == 10:
+		return digits, "(" + digits[:3] + ") " + digits[3:6] + "-" + digits[6:], true
+	case psf == 2 && len(digits) == 7:
+		return digits, digits[:3] + "-" + digits[3:], true
+	case psf == 3 && len(digits) == 9:
+		return digits, digits[:3] + "-" + digits[3:5] + "-" + digits[5:], true
+	}
+	return "", "", false
+}
@@ -0,0 +1,120 @@
+package pdfform
+
+import (
+	"sort"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// FieldInfo describes a form field.
+type FieldInfo struct {
+	// Name is the fully qualified name of the field.
+	Name string
+	// Type is the type of the field: "text", "checkbox", "radio", "combo",
+	// "list", "pushbutton", or "signature".
+	Type string
+	// Value is the current value of the field.
+	Value string
+	// Options is the list of allowed values for the field, for checkboxes,
+	// radio buttons, and choice fields that don't allow other values.
+	Options []string
+}
+
+// Button field flags.
+const (
+	buttonRadio      = 1 << 15
+	buttonPushButton = 1 << 16
+)
+
+// GetFieldInfo returns descriptions of all of the terminal fields in the PDF,
+// in the order in which they appear in the form.
+func GetFieldInfo(pdf *pdfstruct.PDF) (infos []FieldInfo, err error) {
+	var fields []*fieldNode
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return nil, err
+	}
+	err = walkFields(fields, func(f *fieldNode) (err error) {
+		var info = FieldInfo{Name: f.name, Type: fieldKind(f), Value: valueString(f)}
+		switch info.Type {
+		case "checkbox", "radio":
+			if info.Options, err = buttonStates(pdf, f); err != nil {
+				return err
+			}
+		case "combo", "list":
+			if f.flags()&choiceEdit == 0 {
+				if info.Options, err = choiceOptions(pdf, f); err != nil {
+					return err
+				}
+			}
+		}
+		infos = append(infos, info)
+		return nil
+	})
+	return infos, err
+}
+
+// valueString returns the (possibly inherited) value of a terminal field as a
+// string.  For fields with multiple values, it returns the first.
+func valueString(f *fieldNode) string {
+	switch v := fieldValue(f).(type) {
+	case string:
+		return decodeText(v)
+	case pdfstruct.Name:
+		return string(v)
+	case pdfstruct.Array:
+		if len(v) != 0 {
+			return decodeText(v[0].(string))
+		}
+	}
+	return ""
+}
+
+// fieldKind returns the kind of a terminal field, as described in FieldInfo.
+func fieldKind(f *fieldNode) string {
+	switch f.fieldType() {
+	case "Tx":
+		return "text"
+	case "Ch":
+		if f.flags()&choiceCombo != 0 {
+			return "combo"
+		}
+		return "list"
+	case "Sig":
+		return "signature"
+	case "Btn":
+		switch {
+		case f.flags()&buttonPushButton != 0:
+			return "pushbutton"
+		case f.flags()&buttonRadio != 0, f.dict["Kids"] != nil:
+			// This test for radio buttons matches setButton.
+			return "radio"
+		default:
+			return "checkbox"
+		}
+	}
+	return ""
+}
+
+// buttonStates returns the list of appearance states of the widgets of a
+// checkbox or radio button field, which are the values the field can take.
+// "Off" is always first.
+func buttonStates(pdf *pdfstruct.PDF, f *fieldNode) (states []string, err error) {
+	var seen = map[pdfstruct.Name]bool{"Off": true}
+	for _, w := range f.widgets {
+		var ap, apn pdfstruct.Dict
+		if ap, err = dictOf(pdf, w.dict["AP"]); err != nil {
+			continue
+		}
+		if apn, err = dictOf(pdf, ap["N"]); err != nil {
+			continue
+		}
+		for state := range apn {
+			if !seen[state] {
+				seen[state] = true
+				states = append(states, string(state))
+			}
+		}
+	}
+	sort.Strings(states)
+	return append([]string{"Off"}, states...), nil
+}
@@ -0,0 +1,229 @@
+package pdfform
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+/*
+Signatures can restrict later changes to the document in two ways.
+
+A signature field can lock other fields when it is signed.  The fields are
+listed in the signature field's Lock dictionary, and (once signed) in a
+FieldMDP transform in the signature dictionary's Reference array:
+    /Lock = Dict<<
+        /Type = /SigFieldLock
+        /Action = /Include			[or /All or /Exclude]
+        /Fields = Array["Name", "Addr"]		[fully qualified names]
+        /P = 2					[optional; as DocMDP]
+    >>
+    /V = Dict<<
+        /Reference = Array[
+            [0] = Dict<<
+                /Type = /SigRef
+                /TransformMethod = /FieldMDP
+                /TransformParams = Dict<< /Action /Include /Fields [...] >>
+            >>
+        ]
+        ...
+    >>
+Naming a non-terminal field in Fields locks all of its descendants.
+
+A certification signature restricts changes to the whole document.  The
+catalog's Perms[DocMDP] refers to its signature dictionary, which has a DocMDP
+transform in its Reference array, with TransformParams /P: 1 means no changes
+are allowed; 2 (the default) allows filling in forms and signing; 3 also allows
+annotation changes.
+*/
+
+// Document modification permissions (DocMDP P values).
+const (
+	mdpNoChanges = 1
+	mdpFillForms = 2
+	mdpAnnotate  = 3
+)
+
+// A fieldLock is a set of fields locked by a signature.
+type fieldLock struct {
+	sig    string   // name of the signature field
+	action string   // All, Include, or Exclude
+	fields []string // fully qualified names for Include and Exclude
+}
+
+// locks returns whether the lock applies to the named field.
+func (l fieldLock) locks(name string) bool {
+	switch l.action {
+	case "All":
+		return true
+	case "Include":
+		return len(l.fields) != 0 && matchName(name, l.fields)
+	case "Exclude":
+		return len(l.fields) == 0 || !matchName(name, l.fields)
+	}
+	return false
+}
+
+// getLocks returns the field locks imposed by the signed signature fields in
+// the form, and the most restrictive document modification permission imposed
+// by any signature (mdpAnnotate if there is none).
+func getLocks(pdf *pdfstruct.PDF, fields []*fieldNode) (locks []fieldLock, perm int, err error) {
+	if perm, err = docMDP(pdf); err != nil {
+		return nil, 0, err
+	}
+	err = walkFields(fields, func(f *fieldNode) (err error) {
+		var v, lock pdfstruct.Dict
+		if f.fieldType() != "Sig" {
+			return nil
+		}
+		if v, err = dictOf(pdf, f.dict["V"]); err != nil {
+			return fmt.Errorf("%s: field[V]: %s", f.name, err)
+		}
+		if v == nil {
+			return nil // locks apply only once signed
+		}
+		if lock, err = dictOf(pdf, f.dict["Lock"]); err != nil {
+			return fmt.Errorf("%s: field[Lock]: %s", f.name, err)
+		}
+		if lock != nil {
+			var l fieldLock
+			if l, err = readFieldLock(pdf, f.name, lock); err != nil {
+				return fmt.Errorf("%s: field[Lock]: %s", f.name, err)
+			}
+			locks = append(locks, l)
+			if p, ok := lock["P"].(int); ok && p >= mdpNoChanges && p < perm {
+				perm = p
+			}
+		}
+		return signatureReferences(pdf, v, func(method pdfstruct.Name, params pdfstruct.Dict) (err error) {
+			if method == "FieldMDP" && params != nil {
+				var l fieldLock
+				if l, err = readFieldLock(pdf, f.name, params); err != nil {
+					return fmt.Errorf("%s: field[V][Reference]: %s", f.name, err)
+				}
+				locks = append(locks, l)
+			}
+			return nil
+		})
+	})
+	return locks, perm, err
+}
+
+// readFieldLock reads a Lock dictionary or FieldMDP transform parameters.
+func readFieldLock(pdf *pdfstruct.PDF, sig string, dict pdfstruct.Dict) (l fieldLock, err error) {
+	var list pdfstruct.Array
+
+	l.sig = sig
+	if action, ok := dict["Action"].(pdfstruct.Name); ok {
+		l.action = string(action)
+	} else {
+		return l, errors.New("[Action] is not a Name")
+	}
+	if list, err = arrayOf(pdf, dict["Fields"]); err != nil {
+		return l, fmt.Errorf("[Fields]: %s", err)
+	}
+	for _, name := range list {
+		if s, ok := name.(string); ok {
+			l.fields = append(l.fields, decodeText(s))
+		}
+	}
+	return l, nil
+}
+
+// docMDP returns the document modification permission set by the document's
+// certification signature, if any.  It returns mdpAnnotate if there is none.
+func docMDP(pdf *pdfstruct.PDF) (perm int, err error) {
+	var perms, sig pdfstruct.Dict
+
+	perm = mdpAnnotate
+	if perms, err = dictOf(pdf, pdf.Catalog["Perms"]); err != nil {
+		return 0, fmt.Errorf("Perms: %s", err)
+	}
+	if sig, err = dictOf(pdf, perms["DocMDP"]); err != nil {
+		return 0, fmt.Errorf("Perms[DocMDP]: %s", err)
+	}
+	if sig == nil {
+		return perm, nil
+	}
+	err = signatureReferences(pdf, sig, func(method pdfstruct.Name, params pdfstruct.Dict) error {
+		if method == "DocMDP" {
+			perm = mdpFillForms
+			if p, ok := params["P"].(int); ok && p >= mdpNoChanges && p <= mdpAnnotate {
+				perm = p
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Perms[DocMDP]: %s", err)
+	}
+	return perm, nil
+}
+
+// signatureReferences calls fn with the transform method and parameters of each
+// of the signature references in a signature dictionary.
+func signatureReferences(pdf *pdfstruct.PDF, sig pdfstruct.Dict, fn func(pdfstruct.Name, pdfstruct.Dict) error) (err error) {
+	var refs pdfstruct.Array
+
+	if refs, err = arrayOf(pdf, sig["Reference"]); err != nil {
+		return fmt.Errorf("[Reference]: %s", err)
+	}
+	for i, r := range refs {
+		var sigref, params pdfstruct.Dict
+		if sigref, err = dictOf(pdf, r); err != nil {
+			return fmt.Errorf("[Reference][%d]: %s", i, err)
+		}
+		if params, err = dictOf(pdf, sigref["TransformParams"]); err != nil {
+			return fmt.Errorf("[Reference][%d][TransformParams]: %s", i, err)
+		}
+		method, _ := sigref["TransformMethod"].(pdfstruct.Name)
+		if err = fn(method, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockedFields returns the fully qualified names of the terminal fields that
+// cannot be changed without invalidating a signature, in the order in which
+// they appear in the form.  These are the fields locked by signed signature
+// fields, or all fields if the document has a certification signature that
+// allows no changes.  Signed signature fields are not included.
+func LockedFields(pdf *pdfstruct.PDF) (names []string, err error) {
+	var (
+		fields []*fieldNode
+		locks  []fieldLock
+		perm   int
+	)
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return nil, err
+	}
+	if locks, perm, err = getLocks(pdf, fields); err != nil {
+		return nil, err
+	}
+	err = walkFields(fields, func(f *fieldNode) error {
+		if f.fieldType() == "Sig" && f.dict["V"] != nil {
+			return nil
+		}
+		if fieldLockError(f, locks, perm) != nil {
+			names = append(names, f.name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// fieldLockError returns an error if the field is locked by any of the locks,
+// or if the document modification permission doesn't allow filling in forms.
+func fieldLockError(field *fieldNode, locks []fieldLock, perm int) error {
+	if perm == mdpNoChanges {
+		return errors.New("document is certified with no changes allowed")
+	}
+	for _, l := range locks {
+		if l.locks(field.name) {
+			return fmt.Errorf("field is locked by signature %q", l.sig)
+		}
+	}
+	return nil
+}
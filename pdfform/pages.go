@@ -0,0 +1,204 @@
+package pdfform
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// A pageNode is a page in the document's page tree.
+type pageNode struct {
+	ref    pdfstruct.Reference
+	dict   pdfstruct.Dict
+	parent pdfstruct.Reference // the Pages node containing the page
+}
+
+// getPages returns the pages of the document, in order.
+func getPages(pdf *pdfstruct.PDF) (pages []*pageNode, err error) {
+	root, ok := pdf.Catalog["Pages"].(pdfstruct.Reference)
+	if !ok {
+		return nil, errors.New("Pages is not a Reference")
+	}
+	return getPageTree(pdf, root, pages, 0)
+}
+
+// getPageTree adds the pages under the specified Pages node to the list.
+func getPageTree(
+	pdf *pdfstruct.PDF, ref pdfstruct.Reference, pages []*pageNode, depth int,
+) (_ []*pageNode, err error) {
+	var (
+		node pdfstruct.Dict
+		kids pdfstruct.Array
+	)
+	if depth > 64 {
+		return nil, errors.New("page tree is too deep")
+	}
+	if node, err = pdf.GetDict(ref); err != nil {
+		return nil, err
+	}
+	if kids, err = arrayOf(pdf, node["Kids"]); err != nil {
+		return nil, fmt.Errorf("Pages[Kids]: %s", err)
+	}
+	for i, k := range kids {
+		var (
+			kref pdfstruct.Reference
+			kid  pdfstruct.Dict
+			ok   bool
+		)
+		if kref, ok = k.(pdfstruct.Reference); !ok {
+			return nil, fmt.Errorf("Pages[Kids][%d] is not a Reference", i)
+		}
+		if kid, err = pdf.GetDict(kref); err != nil {
+			return nil, fmt.Errorf("Pages[Kids][%d]: %s", i, err)
+		}
+		if kid["Type"] == pdfstruct.Name("Pages") {
+			if pages, err = getPageTree(pdf, kref, pages, depth+1); err != nil {
+				return nil, err
+			}
+		} else {
+			pages = append(pages, &pageNode{ref: kref, dict: kid, parent: ref})
+		}
+	}
+	return pages, nil
+}
+
+// inherited returns the value of the specified key in the page dictionary, or
+// in the nearest ancestor Pages node that has it.
+func (pg *pageNode) inherited(pdf *pdfstruct.PDF, key pdfstruct.Name) (obj pdfstruct.Object, err error) {
+	if obj, ok := pg.dict[key]; ok {
+		return obj, nil
+	}
+	var ref = pg.parent
+	for depth := 0; ref.Number != 0 && depth <= 64; depth++ {
+		var node pdfstruct.Dict
+		if node, err = pdf.GetDict(ref); err != nil {
+			return nil, err
+		}
+		if obj, ok := node[key]; ok {
+			return obj, nil
+		}
+		ref, _ = node["Parent"].(pdfstruct.Reference)
+	}
+	return nil, nil
+}
+
+// insertPages inserts new pages into the page tree next to (before or after)
+// the specified page, and updates the page counts of its ancestors.  The new
+// pages must already have their Parent set to neighbor.parent.
+func insertPages(pdf *pdfstruct.PDF, neighbor *pageNode, before bool, refs []pdfstruct.Reference) (err error) {
+	var (
+		ref   = neighbor.parent
+		node  pdfstruct.Dict
+		kids  pdfstruct.Array
+		found bool
+	)
+	if node, err = pdf.GetDict(ref); err != nil {
+		return fmt.Errorf("Pages: %s", err)
+	}
+	if kids, err = arrayOf(pdf, node["Kids"]); err != nil {
+		return fmt.Errorf("Pages[Kids]: %s", err)
+	}
+	for i, k := range kids {
+		if k == neighbor.ref {
+			if !before {
+				i++
+			}
+			var nk = make(pdfstruct.Array, 0, len(kids)+len(refs))
+			nk = append(nk, kids[:i]...)
+			for _, r := range refs {
+				nk = append(nk, r)
+			}
+			kids, found = append(nk, kids[i:]...), true
+			break
+		}
+	}
+	if !found {
+		return errors.New("page is not in its parent's Kids")
+	}
+	if kref, ok := node["Kids"].(pdfstruct.Reference); ok {
+		pdf.UpdateObject(kref, kids)
+	} else {
+		node["Kids"] = kids
+	}
+	// Update the counts of the parent and all of its ancestors.
+	for depth := 0; ref.Number != 0 && depth <= 64; depth++ {
+		if depth != 0 {
+			if node, err = pdf.GetDict(ref); err != nil {
+				return fmt.Errorf("Pages: %s", err)
+			}
+		}
+		if count, ok := node["Count"].(int); ok {
+			node["Count"] = count + len(refs)
+		} else {
+			return errors.New("Pages[Count] is not an int")
+		}
+		pdf.UpdateObject(ref, node)
+		ref, _ = node["Parent"].(pdfstruct.Reference)
+	}
+	return nil
+}
+
+// annots returns the list of annotations on the page.
+func (pg *pageNode) annots(pdf *pdfstruct.PDF) (annots pdfstruct.Array, err error) {
+	if annots, err = arrayOf(pdf, pg.dict["Annots"]); err != nil {
+		return nil, fmt.Errorf("page[Annots]: %s", err)
+	}
+	return annots, nil
+}
+
+// removeAnnots removes the specified annotations from the page.
+func (pg *pageNode) removeAnnots(pdf *pdfstruct.PDF, refs map[pdfstruct.Reference]bool) (err error) {
+	if err = removeRefs(pdf, pg.dict, "Annots", refs, func() error {
+		pdf.UpdateObject(pg.ref, pg.dict)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("page[Annots]: %s", err)
+	}
+	return nil
+}
+
+// addAnnot adds an annotation to the page.
+func (pg *pageNode) addAnnot(pdf *pdfstruct.PDF, ref pdfstruct.Reference) (err error) {
+	if err = appendRef(pdf, pg.dict, "Annots", ref, func() error {
+		pdf.UpdateObject(pg.ref, pg.dict)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("page[Annots]: %s", err)
+	}
+	return nil
+}
+
+// indexAnnots returns a map from annotation reference to the page containing
+// the annotation, for all annotations on the supplied pages.
+func indexAnnots(pdf *pdfstruct.PDF, pages []*pageNode) (index map[pdfstruct.Reference]*pageNode, err error) {
+	index = make(map[pdfstruct.Reference]*pageNode)
+	for i, pg := range pages {
+		var annots pdfstruct.Array
+		if annots, err = pg.annots(pdf); err != nil {
+			return nil, fmt.Errorf("page %d: %s", i+1, err)
+		}
+		for _, a := range annots {
+			if ref, ok := a.(pdfstruct.Reference); ok {
+				index[ref] = pg
+			}
+		}
+	}
+	return index, nil
+}
+
+// widgetPage returns the page containing the specified widget, or nil if it
+// can't be found.  index is the result of indexAnnots.
+func widgetPage(pages []*pageNode, index map[pdfstruct.Reference]*pageNode, widget *fieldNode) *pageNode {
+	if pg := index[widget.ref]; pg != nil && widget.ref.Number != 0 {
+		return pg
+	}
+	if p, ok := widget.dict["P"].(pdfstruct.Reference); ok {
+		for _, pg := range pages {
+			if pg.ref == p {
+				return pg
+			}
+		}
+	}
+	return nil
+}
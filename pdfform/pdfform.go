@@ -4,7 +4,6 @@ package pdfform
 import (
 	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/rothskeller/pdf/pdfstruct"
 )
@@ -85,7 +84,7 @@ func getField(p *pdfstruct.PDF, fields map[string]string, obj pdfstruct.Object,
 		case nil:
 			break
 		case string:
-			name += "." + n
+			name += "." + decodeText(n)
 		default:
 			return fmt.Errorf("path[%d]/T is not a string", i)
 		}
@@ -93,9 +92,11 @@ func getField(p *pdfstruct.PDF, fields map[string]string, obj pdfstruct.Object,
 		case nil:
 			break
 		case string:
-			value = v
+			value = decodeText(v)
 		case pdfstruct.Name:
 			value = string(v)
+		case pdfstruct.Dict, pdfstruct.Reference:
+			break // signature value
 		default:
 			return fmt.Errorf("path[%d]/V is not a string or Name", i)
 		}
@@ -106,113 +107,205 @@ func getField(p *pdfstruct.PDF, fields map[string]string, obj pdfstruct.Object,
 	return nil
 }
 
+// FontFallback specifies what SetField does when a text field's default
+// appearance names a font that is not defined in the form's default resources,
+// so that no appearance stream can be generated for the field.
+type FontFallback int
+
+const (
+	// FallbackNone causes SetField to return an error.  This is the
+	// default.
+	FallbackNone FontFallback = iota
+	// FallbackNeedAppearances causes SetField to set the field value with
+	// a basic appearance in the standard Helvetica font (added to
+	// AcroForm[DR][Font] as /Helv), and to set AcroForm[NeedAppearances] so
+	// that the PDF reader will generate a better one.
+	FallbackNeedAppearances
+	// FallbackHelvetica causes SetField to add the standard Helvetica font
+	// to AcroForm[DR][Font] (as /Helv) and use it for the appearance.
+	FallbackHelvetica
+)
+
+// Options are the optional settings for SetFieldWithOptions.
+type Options struct {
+	// FontSize is the font size to use for text fields that do not have a
+	// font size supplied in the PDF.  If it is zero too, a size that fits
+	// the field height is used.  It is ignored for other fields.
+	FontSize float64
+	// FontFallback says what to do when a text field's font is not
+	// defined in the form.
+	FontFallback FontFallback
+}
+
 // SetField sets the value of a field in the PDF.  The change does not take
 // effect until the caller calls Write on the underlying PDF.
 func SetField(pdf *pdfstruct.PDF, name, value string, fontSize float64) (err error) {
-	var form pdfstruct.Dict
-	switch f := pdf.Catalog["AcroForm"].(type) {
-	case nil:
-		return errors.New("PDF does not have any form fields")
-	case pdfstruct.Reference:
-		if form, err = pdf.GetDict(f); err != nil {
-			return fmt.Errorf("AcroForm: %s", err)
+	return SetFieldWithOptions(pdf, name, value, Options{FontSize: fontSize})
+}
+
+// SetFieldWithOptions sets the value of a field in the PDF, with the specified
+// options.  The change does not take effect until the caller calls Write on the
+// underlying PDF.
+func SetFieldWithOptions(pdf *pdfstruct.PDF, name, value string, opts Options) (err error) {
+	var (
+		form   pdfstruct.Dict
+		fields []*fieldNode
+		locks  []fieldLock
+		perm   int
+		xfa    = xfaSync{pdf: pdf}
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if locks, perm, err = getLocks(pdf, fields); err != nil {
+		return err
+	}
+	defer xfa.save()
+	return setField(pdf, form, fields, locks, perm, &xfa, name, value, opts)
+}
+
+// A FieldValue is a field name and value, for SetFields.
+type FieldValue struct {
+	// Name is the fully qualified name of the field.
+	Name string
+	// Value is the value to set it to.
+	Value string
+}
+
+// SetFields sets the values of several fields in the PDF, with the specified
+// options, as a series of calls to SetFieldWithOptions would, in the order
+// given.  It is much faster for more than a few fields, since it reads the
+// form (and its XFA description, if any) only once.  If it returns an error,
+// the fields before the one named in the error have been set.  The changes do
+// not take effect until the caller calls Write on the underlying PDF.
+func SetFields(pdf *pdfstruct.PDF, values []FieldValue, opts Options) (err error) {
+	var (
+		form   pdfstruct.Dict
+		fields []*fieldNode
+		locks  []fieldLock
+		perm   int
+		xfa    = xfaSync{pdf: pdf}
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if locks, perm, err = getLocks(pdf, fields); err != nil {
+		return err
+	}
+	defer xfa.save()
+	for _, v := range values {
+		if err = setField(pdf, form, fields, locks, perm, &xfa, v.Name, v.Value, opts); err != nil {
+			return fmt.Errorf("%s: %s", v.Name, err)
 		}
-	case pdfstruct.Dict:
-		form = f
-	default:
-		return errors.New("AcroForm is not a Dict")
 	}
-	var fields pdfstruct.Array
-	switch a := form["Fields"].(type) {
-	case nil:
+	return nil
+}
+
+// setField sets the value of a field in the form, given its field tree and the
+// locks on it, and records the change in xfa.
+func setField(
+	pdf *pdfstruct.PDF, form pdfstruct.Dict, fields []*fieldNode, locks []fieldLock, perm int, xfa *xfaSync,
+	name, value string, opts Options,
+) (err error) {
+	var field *fieldNode
+
+	if len(fields) == 0 {
 		return errors.New("PDF does not have any form fields")
-	case pdfstruct.Reference:
-		if fields, err = pdf.GetArray(a); err != nil {
-			return fmt.Errorf("AcroForm[Fields]: %s", err)
+	}
+	if field = findField(fields, name); field == nil {
+		return errors.New("no such field in form")
+	}
+	if !field.terminal() {
+		return fmt.Errorf("%q is not a terminal field", name)
+	}
+	if field.ref.Number == 0 {
+		return fmt.Errorf("field %q is not a Reference", name)
+	}
+	if err = fieldLockError(field, locks, perm); err != nil {
+		if sameValue(pdf, field, value) {
+			return nil // setting a locked field to its current value is harmless
 		}
-	case pdfstruct.Array:
-		fields = a
+		return err
+	}
+	switch ftype := field.fieldType(); ftype {
+	case "Btn":
+		err = setButton(pdf, field, value)
+	case "Tx":
+		err = setText(pdf, form, field, value, opts)
+	case "Ch":
+		err = setChoice(pdf, field, value)
+	case "":
+		return fmt.Errorf("field %q has no FT", name)
 	default:
-		return errors.New("AcroForm[Fields] is not an Array")
-	}
-LOOP:
-	for i, f := range fields {
-		var (
-			fieldref pdfstruct.Reference
-			field    pdfstruct.Dict
-			want     string
-			fname    string
-			ftype    pdfstruct.Name
-			ok       bool
-		)
-		if fieldref, ok = f.(pdfstruct.Reference); !ok {
-			return errors.New("AcroForm[Fields] element is not a Reference")
-		}
-		if field, err = pdf.GetDict(fieldref); err != nil {
-			return fmt.Errorf("AcroForm[Fields][%d]: %s", i, err)
-		}
-		if fname, ok = field["T"].(string); !ok {
-			return fmt.Errorf("AcroForm[Fields][%d][T] is not a string", i)
-		}
-		want = name
-		idx := strings.IndexByte(want, '.')
-		if idx >= 0 {
-			want = want[:idx]
+		return fmt.Errorf("field type %q is not supported", ftype)
+	}
+	if err != nil {
+		return err
+	}
+	// If the form also has an XFA description, keep its data in sync.
+	return xfa.setField(field)
+}
+
+// sameValue returns whether setting a terminal field to value would leave its
+// value unchanged.
+func sameValue(pdf *pdfstruct.PDF, field *fieldNode, value string) bool {
+	var current = valueString(field)
+	if list, ok := fieldValue(field).(pdfstruct.Array); ok && len(list) > 1 {
+		return false
+	}
+	switch field.fieldType() {
+	case "Btn":
+		if current == "" {
+			current = "Off"
 		}
-		if fname != want {
-			continue
+		if value == "" {
+			value = "Off"
 		}
-		if idx >= 0 {
-			name = name[idx+1:]
-			switch k := field["Kids"].(type) {
-			case pdfstruct.Array:
-				fields = k
-			case pdfstruct.Reference:
-				if fields, err = pdf.GetArray(k); err != nil {
-					return err
-				}
-			default:
-				return errors.New("expected hierarchical parent but Kids is not an Array")
+		if value == "Yes" && fieldKind(field) == "checkbox" {
+			if on, err := checkboxOnState(pdf, field); err == nil && on != "" {
+				value = string(on)
 			}
-			goto LOOP
-		}
-		if ftype, ok = field["FT"].(pdfstruct.Name); !ok {
-			return fmt.Errorf("AcroForm[Fields][%d][FT] is not a Name", i)
 		}
-		switch ftype {
-		case "Btn":
-			return setButton(pdf, fieldref, field, value)
-		case "Tx":
-			return setText(pdf, form, field, fieldref, value, fontSize)
-		case "Ch":
-			return setChoice(pdf, fieldref, field, value)
-		default:
-			return fmt.Errorf("field type %q is not supported", ftype)
+	case "Tx":
+		if stored, _, err := textFormat(pdf, field, value); err == nil {
+			value = stored
 		}
 	}
-	return errors.New("no such field in form")
+	return value == current
 }
 
-func setButton(pdf *pdfstruct.PDF, fieldref pdfstruct.Reference, field pdfstruct.Dict, value string) (err error) {
-	var flags int
-	switch f := field["Ff"].(type) {
-	case nil:
-		flags = 0
-	case int:
-		flags = f
-	default:
-		return errors.New("field[Ff] is not an int")
-	}
-	if flags&(1<<16) != 0 {
+func setButton(pdf *pdfstruct.PDF, field *fieldNode, value string) (err error) {
+	var flags = field.flags()
+	if flags&buttonPushButton != 0 {
 		return errors.New("field is a push button and doesn't have a value")
 	}
-	if flags&(1<<15) != 0 {
-		return setRadioButton(pdf, fieldref, field, value)
+	if flags&buttonRadio != 0 {
+		return setRadioButton(pdf, field, value)
 	}
-	if field["Kids"] != nil {
+	if field.dict["Kids"] != nil {
 		// I've seen it happen where the kids have the Ff value that
 		// marks it as a radio button.
-		return setRadioButton(pdf, fieldref, field, value)
+		return setRadioButton(pdf, field, value)
+	}
+	return setCheckbox(pdf, field, value)
+}
+
+// updateForm saves a change to the AcroForm dictionary.  If the dictionary is
+// stored directly in the document catalog, the catalog is saved instead.
+func updateForm(pdf *pdfstruct.PDF, form pdfstruct.Dict) (err error) {
+	if ref, ok := pdf.Catalog["AcroForm"].(pdfstruct.Reference); ok {
+		pdf.UpdateObject(ref, form)
+		return nil
+	}
+	pdf.Catalog["AcroForm"] = form
+	return updateCatalog(pdf)
+}
+
+// updateCatalog saves a change to the document catalog.
+func updateCatalog(pdf *pdfstruct.PDF) (err error) {
+	if ref, ok := pdf.Info["Root"].(pdfstruct.Reference); ok {
+		pdf.UpdateObject(ref, pdf.Catalog)
+		return nil
 	}
-	return setCheckbox(pdf, fieldref, field, value)
+	return errors.New("document Root is not a Reference")
 }
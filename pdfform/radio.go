@@ -51,54 +51,29 @@ field data but leaves it uneditable.)
 
 // setRadioButton sets the state of a set of radio buttons.  This involves
 // setting V on the parent field and /AS on each of the individual buttons.
-func setRadioButton(pdf *pdfstruct.PDF, fieldref pdfstruct.Reference, field pdfstruct.Dict, value string) (err error) {
+func setRadioButton(pdf *pdfstruct.PDF, field *fieldNode, value string) (err error) {
 	var found bool
 
 	// Update the V in the parent field.
-	if v, ok := field["V"].(pdfstruct.Name); ok && string(v) == value {
+	if v, ok := field.dict["V"].(pdfstruct.Name); ok && string(v) == value {
 		return nil // no change needed
 	}
 	if value == "Off" {
-		delete(field, "V")
+		delete(field.dict, "V")
 		found = true
 	} else {
-		field["V"] = pdfstruct.Name(value)
+		field.dict["V"] = pdfstruct.Name(value)
 	}
-	pdf.UpdateObject(fieldref, field)
+	field.update(pdf)
 	// Update the /AS of each of the Kids.  While doing so, make sure the
 	// chosen value is valid.
-	var kids pdfstruct.Array
-	switch k := field["Kids"].(type) {
-	case nil:
+	if field.dict["Kids"] == nil {
 		return errors.New("field[Kids] doesn't exist")
-	case pdfstruct.Reference:
-		if kids, err = pdf.GetArray(k); err != nil {
-			return fmt.Errorf("field[Kids]: %s", err)
-		}
-	case pdfstruct.Array:
-		kids = k
-	default:
-		return errors.New("field[Kids] is not an Array")
 	}
-	for i, k := range kids {
-		// Get the kid Dict.
-		var kid pdfstruct.Dict
-		var kidref pdfstruct.Reference
-		switch k := k.(type) {
-		case pdfstruct.Reference:
-			if kid, err = pdf.GetDict(k); err != nil {
-				return fmt.Errorf("field[Kids][%d]: %s", i, err)
-			}
-			kidref = k
-		case pdfstruct.Dict:
-			kid = k
-			kidref = fieldref
-		default:
-			return fmt.Errorf("field[Kids][%d] is not a Dict", i)
-		}
+	for i, kid := range field.widgets {
 		// Get the kid's AP dict.
 		var ap pdfstruct.Dict
-		switch a := kid["AP"].(type) {
+		switch a := kid.dict["AP"].(type) {
 		case pdfstruct.Reference:
 			if ap, err = pdf.GetDict(a); err != nil {
 				return fmt.Errorf("field[Kids][%d][AP]: %s", i, err)
@@ -124,23 +99,19 @@ func setRadioButton(pdf *pdfstruct.PDF, fieldref pdfstruct.Reference, field pdfs
 		if _, ok := apn[pdfstruct.Name(value)]; ok {
 			// Yes, so set the /AS for this kid to that value.
 			found = true
-			kid["AS"] = pdfstruct.Name(value)
-			if kidref != fieldref {
-				pdf.UpdateObject(kidref, kid)
-			}
+			kid.dict["AS"] = pdfstruct.Name(value)
+			kid.update(pdf)
 		} else {
 			// No, so set the /AS for this kid to /Off, if it isn't
 			// already.
-			if kid["AS"] != pdfstruct.Name("Off") {
-				kid["AS"] = pdfstruct.Name("Off")
-				if kidref != fieldref {
-					pdf.UpdateObject(kidref, kid)
-				}
+			if kid.dict["AS"] != pdfstruct.Name("Off") {
+				kid.dict["AS"] = pdfstruct.Name("Off")
+				kid.update(pdf)
 			}
 		}
 	}
 	if !found {
-		return fmt.Errorf("value %q is not valid for field %q", value, field["T"])
+		return fmt.Errorf("value %q is not valid for field %q", value, field.name)
 	}
 	return nil
 }
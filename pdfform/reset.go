@@ -0,0 +1,104 @@
+package pdfform
+
+import (
+	"fmt"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// ResetFields resets form fields to their default values, following the
+// semantics of the PDF ResetForm action: each field's value is set to its
+// default value (DV) if it has one, and removed otherwise.  Checkboxes and
+// radio buttons without a default value are turned off.  The appearances of
+// the fields are regenerated to match; opts are used as in SetFieldWithOptions.
+//
+// names is the list of fully qualified names of the fields to be reset;
+// naming a non-terminal field resets all of its descendants.  If no names are
+// given, all fields are reset.  The result is not applied until p.Write is
+// called.
+func ResetFields(pdf *pdfstruct.PDF, opts Options, names ...string) (err error) {
+	var (
+		form   pdfstruct.Dict
+		fields []*fieldNode
+		locks  []fieldLock
+		perm   int
+		xfa    = xfaSync{pdf: pdf}
+	)
+	if form, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if locks, perm, err = getLocks(pdf, fields); err != nil {
+		return err
+	}
+	defer xfa.save()
+	return walkFields(fields, func(f *fieldNode) (err error) {
+		if !matchName(f.name, names) || f.fieldType() == "Sig" {
+			return nil
+		}
+		if err = fieldLockError(f, locks, perm); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		if err = resetField(pdf, form, f, opts); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		// If the form also has an XFA description, keep its data in
+		// sync.
+		if err = xfa.setField(f); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		return nil
+	})
+}
+
+// resetField resets a single terminal field to its default value.
+func resetField(pdf *pdfstruct.PDF, form pdfstruct.Dict, field *fieldNode, opts Options) (err error) {
+	var dv = field.inherited("DV")
+
+	if r, ok := dv.(pdfstruct.Reference); ok {
+		if dv, err = pdf.Get(r); err != nil {
+			return fmt.Errorf("field[DV]: %s", err)
+		}
+	}
+	if a, ok := dv.(pdfstruct.Array); ok {
+		// We don't support multiple selections; use the first.
+		if len(a) != 0 {
+			dv = a[0]
+		} else {
+			dv = nil
+		}
+	}
+	switch field.fieldType() {
+	case "Btn":
+		if field.flags()&buttonPushButton != 0 {
+			return nil
+		}
+		var value = "Off"
+		if n, ok := dv.(pdfstruct.Name); ok {
+			value = string(n)
+		}
+		return setButton(pdf, field, value)
+	case "Tx":
+		var value string
+		if dv == nil && field.dict["V"] == nil {
+			return nil // already empty
+		}
+		if s, ok := dv.(string); ok {
+			value = decodeText(s)
+		}
+		if err = setText(pdf, form, field, value, opts); err != nil {
+			return err
+		}
+	case "Ch":
+		if s, ok := dv.(string); ok {
+			return setChoice(pdf, field, decodeText(s))
+		}
+	default:
+		return nil
+	}
+	// The field has no default value, so it should have no value at all.
+	if dv == nil && field.dict["V"] != nil {
+		delete(field.dict, "V")
+		field.update(pdf)
+	}
+	return nil
+}
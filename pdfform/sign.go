@@ -0,0 +1,274 @@
+package pdfform
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+	"github.com/rothskeller/pdf/pdftext"
+)
+
+// SignOptions are the optional settings for Sign.
+type SignOptions struct {
+	// Name, Reason, Location, and ContactInfo are stored in the signature
+	// dictionary.  Name defaults to the common name of the signer
+	// certificate.
+	Name        string
+	Reason      string
+	Location    string
+	ContactInfo string
+	// SigningTime is the time of signing.  It defaults to the current
+	// time.
+	SigningTime time.Time
+	// Hash is the hash function used for the signature.  It defaults to
+	// SHA-256.
+	Hash crypto.Hash
+	// Reserve is the number of bytes reserved in the file for the
+	// signature.  It defaults to an amount sufficient for the certificate
+	// chain and a typical signature.
+	Reserve int
+}
+
+// byteRangePlaceholder is written in place of each of the offsets in a new
+// signature's ByteRange, so that there is room to patch in the real values
+// after the file is written.  It is the largest int on 32-bit platforms, and
+// has as many digits as any offset in a file of up to 2GB.
+const byteRangePlaceholder = 2147483647
+
+// Sign signs the PDF with an adbe.pkcs7.detached (PAdES basic) signature, using
+// signer as the private key and chain as the certificate chain, starting with
+// the signer's certificate.  field is the fully qualified name of the
+// signature field, which must not already be signed.  If there is no field
+// with that name, an invisible one is created on the first page.  A visible
+// signature field gets an appearance showing the signer name and time.  If the
+// field has a Lock dictionary, the fields it names are locked by the
+// signature.  Sign refuses to sign a document certified with no changes
+// allowed.
+//
+// Sign calls p.Write to write all pending changes, together with the
+// signature field, as an incremental update, and then fills in the signature
+// covering the entire file.  The file handle passed to pdfstruct.Open must
+// support io.WriterAt as well as io.WriteSeeker.  Changes made after signing
+// are written as further incremental updates; they do not invalidate the
+// signature, but they are reported by VerifySignatures.
+func Sign(pdf *pdfstruct.PDF, field string, signer crypto.Signer, chain []*x509.Certificate, opts SignOptions) (err error) {
+	var (
+		form    pdfstruct.Dict
+		fields  []*fieldNode
+		sfield  *fieldNode
+		sigDict pdfstruct.Dict
+		sigRef  pdfstruct.Reference
+		lock    pdfstruct.Dict
+		perm    int
+	)
+	if len(chain) == 0 {
+		return errors.New("no signer certificate")
+	}
+	if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(chain[0].PublicKey) {
+		return errors.New("signer does not match the signer certificate")
+	}
+	if opts.Name == "" {
+		opts.Name = chain[0].Subject.CommonName
+	}
+	if opts.SigningTime.IsZero() {
+		opts.SigningTime = time.Now()
+	}
+	if opts.Hash == 0 {
+		opts.Hash = crypto.SHA256
+	}
+	if opts.Reserve == 0 {
+		opts.Reserve = 8192
+		for _, cert := range chain {
+			opts.Reserve += len(cert.Raw)
+		}
+	}
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return err
+	}
+	if perm, err = docMDP(pdf); err != nil {
+		return err
+	} else if perm == mdpNoChanges {
+		return errors.New("document is certified with no changes allowed")
+	}
+	if sfield = findField(fields, field); sfield == nil {
+		if sfield, err = addSignatureField(pdf, NewField{Name: field}); err != nil {
+			return err
+		}
+	} else if !sfield.terminal() || sfield.fieldType() != "Sig" {
+		return fmt.Errorf("%q is not a signature field", field)
+	} else if sfield.dict["V"] != nil {
+		return fmt.Errorf("field %q is already signed", field)
+	} else if sfield.ref.Number == 0 {
+		return fmt.Errorf("field %q is not a Reference", field)
+	}
+	// Get the form after adding the field, so that we have the updated
+	// copy.
+	if form, err = getForm(pdf); err != nil {
+		return err
+	}
+	// Create the signature dictionary, with placeholders for the byte
+	// range and the signature.
+	sigDict = pdfstruct.Dict{
+		"Type":      pdfstruct.Name("Sig"),
+		"Filter":    pdfstruct.Name("Adobe.PPKLite"),
+		"SubFilter": pdfstruct.Name("adbe.pkcs7.detached"),
+		"ByteRange": pdfstruct.Array{0, byteRangePlaceholder, byteRangePlaceholder, byteRangePlaceholder},
+		"Contents":  make([]byte, opts.Reserve),
+		"M":         formatPDFDate(opts.SigningTime),
+		"Name":      encodeText(opts.Name),
+	}
+	for key, value := range map[pdfstruct.Name]string{
+		"Reason": opts.Reason, "Location": opts.Location, "ContactInfo": opts.ContactInfo,
+	} {
+		if value != "" {
+			sigDict[key] = encodeText(value)
+		}
+	}
+	// If the field locks other fields when signed, record that in the
+	// signature.
+	if lock, err = dictOf(pdf, sfield.dict["Lock"]); err != nil {
+		return fmt.Errorf("field[Lock]: %s", err)
+	}
+	if lock != nil {
+		var params = pdfstruct.Dict{"Type": pdfstruct.Name("TransformParams"), "V": pdfstruct.Name("1.2")}
+		for _, key := range []pdfstruct.Name{"Action", "Fields"} {
+			if v, ok := lock[key]; ok {
+				params[key] = v
+			}
+		}
+		sigDict["Reference"] = pdfstruct.Array{pdfstruct.Dict{
+			"Type":            pdfstruct.Name("SigRef"),
+			"TransformMethod": pdfstruct.Name("FieldMDP"),
+			"TransformParams": params,
+		}}
+	}
+	sigRef = pdf.CreateObject(sigDict)
+	sfield.dict["V"] = sigRef
+	sfield.update(pdf)
+	if err = signatureAppearance(pdf, form, sfield, opts); err != nil {
+		return err
+	}
+	form["SigFlags"] = 3 // signatures exist, append only
+	if err = updateForm(pdf, form); err != nil {
+		return err
+	}
+	if err = pdf.Write(); err != nil {
+		return err
+	}
+	return fillSignature(pdf, sigRef, signer, chain, opts)
+}
+
+// fillSignature fills in the ByteRange and Contents placeholders of a
+// signature dictionary that has just been written.
+func fillSignature(
+	pdf *pdfstruct.PDF, sigRef pdfstruct.Reference, signer crypto.Signer, chain []*x509.Certificate, opts SignOptions,
+) (err error) {
+	var (
+		reserve   = opts.Reserve
+		offset    int64
+		ok        bool
+		size      int64
+		obj       []byte
+		brIdx     int
+		cIdx      int
+		byteRange []int
+		signed    []byte
+		der       []byte
+	)
+	// Find the placeholders in the written signature dictionary.
+	if offset, ok = pdf.ObjectOffset(sigRef); !ok {
+		return errors.New("signature dictionary was not written")
+	}
+	if size, err = pdf.Size(); err != nil {
+		return err
+	}
+	obj = make([]byte, min(size-offset, int64(2*reserve+4096)))
+	if _, err = pdf.ReadAt(obj, offset); err != nil {
+		return err
+	}
+	var brPlaceholder = []byte(fmt.Sprintf("/ByteRange [ 0 %d %d %d ]", byteRangePlaceholder, byteRangePlaceholder, byteRangePlaceholder))
+	if brIdx = bytes.Index(obj, brPlaceholder); brIdx < 0 {
+		return errors.New("signature ByteRange placeholder not found")
+	}
+	brIdx += len("/ByteRange ")
+	var cPlaceholder = []byte("/Contents <" + hex.EncodeToString(make([]byte, reserve)) + ">")
+	if cIdx = bytes.Index(obj, cPlaceholder); cIdx < 0 {
+		return errors.New("signature Contents placeholder not found")
+	}
+	cIdx += len("/Contents ")
+	// Fill in the byte range, which covers everything but the Contents
+	// value.
+	var cStart, cEnd = int(offset) + cIdx, int(offset) + cIdx + 2*reserve + 2
+	if size > byteRangePlaceholder {
+		return errors.New("file is too large to sign")
+	}
+	byteRange = []int{0, cStart, cEnd, int(size) - cEnd}
+	var br = fmt.Sprintf("[ 0 %d %d %d ]", byteRange[1], byteRange[2], byteRange[3])
+	br += string(bytes.Repeat([]byte{' '}, len(brPlaceholder)-len("/ByteRange ")-len(br)))
+	if _, err = pdf.WriteAt([]byte(br), offset+int64(brIdx)); err != nil {
+		return err
+	}
+	// Sign the byte range and fill in the signature.
+	if signed, _, err = signedBytes(pdf, byteRange, size); err != nil {
+		return err
+	}
+	if der, err = signCMS(signed, signer, chain, opts.Hash, opts.SigningTime, rand.Reader); err != nil {
+		return err
+	}
+	if len(der) > reserve {
+		return fmt.Errorf("signature is %d bytes, but only %d bytes were reserved", len(der), reserve)
+	}
+	if _, err = pdf.WriteAt([]byte(hex.EncodeToString(der)), int64(cStart+1)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// signatureAppearance gives the widgets of a visible signature field an
+// appearance showing the signer name and signing time.
+func signatureAppearance(pdf *pdfstruct.PDF, form pdfstruct.Dict, field *fieldNode, opts SignOptions) (err error) {
+	var (
+		fontRef pdfstruct.Reference
+		text    = "Digitally signed by " + opts.Name + "\nDate: " + opts.SigningTime.Format("2006.01.02 15:04:05 -07'00'")
+		style   = pdftext.Style{Font: "Helvetica", FontSize: 10, MinFontSize: 1, LineHeight: 1.2, VAlign: "top"}
+	)
+	for _, w := range field.widgets {
+		var (
+			rect []float64
+			buf  bytes.Buffer
+		)
+		if rect, err = rectOf(pdf, w.dict["Rect"]); err != nil {
+			return fmt.Errorf("widget[Rect]: %s", err)
+		}
+		var width, height = rect[2] - rect[0], rect[3] - rect[1]
+		if width <= 0 || height <= 0 {
+			continue // invisible
+		}
+		if fontRef.Number == 0 {
+			if fontRef, err = textHelvetica(pdf, form); err != nil {
+				return err
+			}
+		}
+		// Draw the text, inset two units from the edges, shrinking it
+		// if needed for both lines to fit.
+		pdftext.DrawContent(&buf, []pdftext.Span{{Text: text}}, 2, 2, width-4, height-4, style,
+			map[string]string{"Helvetica": "Helv"})
+		w.dict["AP"] = pdfstruct.Dict{"N": pdf.CreateObject(appearanceStream(width, height, "Helv", fontRef, buf.Bytes()))}
+		w.update(pdf)
+	}
+	return nil
+}
+
+// formatPDFDate formats a time as a PDF date, D:YYYYMMDDHHmmSSOHH'mm'.
+func formatPDFDate(t time.Time) string {
+	if _, offset := t.Zone(); offset == 0 {
+		return t.Format("D:20060102150405Z")
+	}
+	return t.Format("D:20060102150405-07'00'")
+}
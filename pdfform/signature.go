@@ -0,0 +1,354 @@
+package pdfform
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+/*
+Signature fields are encoded in the PDF as follows:
+    /Root/AcroForm/Fields/9 = (#40,0) -> Dict<<
+        /FT = /Sig				[field type signature]
+        /T = "Signature1"			[field name]
+        /V = (#41,0) -> Dict<<			[signature value]
+            /Type = /Sig
+            /Filter = /Adobe.PPKLite
+            /SubFilter = /adbe.pkcs7.detached
+            /ByteRange = [0 1234 5678 910]	[signed parts of file]
+            /Contents = <3082...0000>		[CMS signature, zero padded]
+            /M = "D:20240102030405-08'00'"	[signing time]
+            /Name = "Jane Doe"
+            /Reason = "I approve"
+            /Location = "Sunnyvale"
+            /ContactInfo = "jane@example.com"
+        >>
+        /Lock = (#42,0)				[fields locked when signed]
+        ... widget annotation entries ...
+    >>
+AcroForm[SigFlags] is 3 (signatures exist, append only) when the PDF contains
+signatures.  The ByteRange covers the whole revision of the file that was
+signed, except for the Contents value (including its angle brackets).
+*/
+
+// Signature describes a signature field and the signature in it.
+type Signature struct {
+	// Field is the fully qualified name of the signature field.
+	Field string
+	// Signed indicates whether the field has been signed.  If not, the
+	// remaining fields are empty.
+	Signed bool
+	// Name, Reason, Location, and ContactInfo are the descriptive entries
+	// of the signature dictionary.
+	Name        string
+	Reason      string
+	Location    string
+	ContactInfo string
+	// SigningTime is the time of signing, taken from the signature itself
+	// if it has one, or else from the signature dictionary.  It is not
+	// trustworthy without a timestamp.
+	SigningTime time.Time
+	// SubFilter is the encoding of the signature, e.g.
+	// "adbe.pkcs7.detached".
+	SubFilter string
+	// ByteRange is the list of (offset, length) pairs of the parts of the
+	// file that are signed.
+	ByteRange []int
+	// Certificates are the certificates embedded in the signature.
+	Certificates []*x509.Certificate
+	// Signer is the certificate of the signer, if it could be found.
+	Signer *x509.Certificate
+
+	// The remaining fields are set by VerifySignatures.
+
+	// Valid indicates that the signature is a correct signature, by the
+	// Signer certificate, of the bytes in ByteRange.
+	Valid bool
+	// Trusted indicates that the Signer certificate chains to one of the
+	// trusted roots passed to VerifySignatures.
+	Trusted bool
+	// Problem describes why the signature is not valid or not trusted.
+	Problem string
+	// WholeFile indicates that the signature covers the entire file, i.e.,
+	// there have been no incremental updates since it was signed.
+	WholeFile bool
+	// Modified lists the objects that were part of the signed revision of
+	// the file but have been changed (or removed) since.  It includes
+	// changes that have not yet been written.
+	Modified []pdfstruct.Reference
+
+	cms      *cmsSignature
+	contents []byte
+}
+
+// GetSignatures returns descriptions of all of the signature fields in the PDF,
+// in the order in which they appear in the form.  It parses the signatures but
+// does not verify them; for that, use VerifySignatures.
+func GetSignatures(pdf *pdfstruct.PDF) (sigs []*Signature, err error) {
+	var fields []*fieldNode
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return nil, err
+	}
+	err = walkFields(fields, func(f *fieldNode) (err error) {
+		var sig *Signature
+		if f.fieldType() != "Sig" {
+			return nil
+		}
+		if sig, err = getSignature(pdf, f); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		sigs = append(sigs, sig)
+		return nil
+	})
+	return sigs, err
+}
+
+// getSignature reads the signature in a signature field.
+func getSignature(pdf *pdfstruct.PDF, field *fieldNode) (sig *Signature, err error) {
+	var (
+		v  pdfstruct.Dict
+		br pdfstruct.Array
+	)
+	sig = &Signature{Field: field.name}
+	if v, err = dictOf(pdf, field.dict["V"]); err != nil {
+		return nil, fmt.Errorf("field[V]: %s", err)
+	}
+	if v == nil {
+		return sig, nil
+	}
+	sig.Signed = true
+	for key, s := range map[pdfstruct.Name]*string{
+		"Name": &sig.Name, "Reason": &sig.Reason, "Location": &sig.Location, "ContactInfo": &sig.ContactInfo,
+	} {
+		if str, ok := v[key].(string); ok {
+			*s = decodeText(str)
+		}
+	}
+	if m, ok := v["M"].(string); ok {
+		sig.SigningTime, _ = parsePDFDate(m)
+	}
+	if sf, ok := v["SubFilter"].(pdfstruct.Name); ok {
+		sig.SubFilter = string(sf)
+	}
+	if br, err = arrayOf(pdf, v["ByteRange"]); err != nil {
+		return nil, fmt.Errorf("field[V][ByteRange]: %s", err)
+	}
+	for _, n := range br {
+		if i, ok := n.(int); ok && i >= 0 {
+			sig.ByteRange = append(sig.ByteRange, i)
+		} else {
+			return nil, errors.New("field[V][ByteRange] is not an Array of offsets")
+		}
+	}
+	if len(sig.ByteRange) == 0 || len(sig.ByteRange)%2 != 0 {
+		return nil, errors.New("field[V][ByteRange] is not an Array of offset and length pairs")
+	}
+	switch c := v["Contents"].(type) {
+	case []byte:
+		sig.contents = c
+	case string:
+		sig.contents = []byte(c)
+	default:
+		return nil, errors.New("field[V][Contents] is not a string")
+	}
+	switch sig.SubFilter {
+	case "adbe.pkcs7.detached", "ETSI.CAdES.detached", "adbe.pkcs7.sha1":
+		if sig.cms, err = parseCMS(sig.contents); err != nil {
+			sig.Problem = err.Error()
+			return sig, nil
+		}
+		sig.Certificates, sig.Signer = sig.cms.certs, sig.cms.signer
+		if !sig.cms.signingTime.IsZero() {
+			sig.SigningTime = sig.cms.signingTime
+		}
+	default:
+		sig.Problem = fmt.Sprintf("signature SubFilter %q is not supported", sig.SubFilter)
+	}
+	return sig, nil
+}
+
+// VerifySignatures returns descriptions of all of the signature fields in the
+// PDF, as GetSignatures does, and verifies each signature that is present.  It
+// checks that the signature is a correct signature of the signed bytes of the
+// file, by the signer certificate embedded in it; that the signer certificate
+// chains to one of the specified trusted roots (if roots is nil, the system
+// roots are used); and whether any objects in the signed revision of the file
+// have been changed by later incremental updates.  Problems with the
+// signatures are reported in the returned descriptions; the error return is
+// used only for failures to read the PDF.
+func VerifySignatures(pdf *pdfstruct.PDF, roots *x509.CertPool) (sigs []*Signature, err error) {
+	if sigs, err = GetSignatures(pdf); err != nil {
+		return nil, err
+	}
+	for _, sig := range sigs {
+		if !sig.Signed {
+			continue
+		}
+		if err = verifySignature(pdf, sig, roots); err != nil {
+			return nil, fmt.Errorf("%s: %s", sig.Field, err)
+		}
+	}
+	return sigs, nil
+}
+
+// verifySignature verifies a single signature.
+func verifySignature(pdf *pdfstruct.PDF, sig *Signature, roots *x509.CertPool) (err error) {
+	var (
+		signed []byte
+		size   int64
+		end    int
+	)
+	if size, err = pdf.Size(); err != nil {
+		return err
+	}
+	if signed, end, err = signedBytes(pdf, sig.ByteRange, size); err != nil {
+		sig.Problem = err.Error()
+		return nil
+	}
+	sig.WholeFile = int64(end) == size
+	if !sig.WholeFile {
+		if sig.Modified, err = modifiedSince(pdf, int64(end)); err != nil {
+			return err
+		}
+	}
+	if sig.cms == nil {
+		return nil // Problem already set by getSignature
+	}
+	if sig.SubFilter == "adbe.pkcs7.sha1" {
+		digest := sha1.Sum(signed)
+		if !bytes.Equal(sig.cms.content, digest[:]) {
+			sig.Problem = "digest of signed bytes does not match signature"
+			return nil
+		}
+		signed = sig.cms.content
+	}
+	if err = sig.cms.verify(signed); err != nil {
+		sig.Problem = err.Error()
+		return nil
+	}
+	sig.Valid = true
+	var opts = x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range sig.Certificates {
+		if cert != sig.Signer {
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+	if !sig.SigningTime.IsZero() {
+		opts.CurrentTime = sig.SigningTime
+	}
+	if _, err = sig.Signer.Verify(opts); err != nil {
+		sig.Problem = fmt.Sprintf("signer certificate is not trusted: %s", err)
+		return nil
+	}
+	sig.Trusted = true
+	return nil
+}
+
+// signedBytes returns the bytes of the file covered by a signature's byte
+// range, and the end of the last range.  It verifies that the ranges are in
+// order and that the gaps between them contain only hex strings (i.e., the
+// signature Contents).
+func signedBytes(pdf *pdfstruct.PDF, byteRange []int, size int64) (signed []byte, end int, err error) {
+	if byteRange[0] != 0 {
+		return nil, 0, errors.New("signed byte range does not start at the beginning of the file")
+	}
+	for i := 0; i < len(byteRange); i += 2 {
+		var (
+			start  = byteRange[i]
+			length = byteRange[i+1]
+		)
+		if start < end || int64(start+length) > size {
+			return nil, 0, errors.New("signed byte range is not valid")
+		}
+		if start > end {
+			var gap = make([]byte, start-end)
+			if _, err = pdf.ReadAt(gap, int64(end)); err != nil {
+				return nil, 0, err
+			}
+			if len(gap) < 2 || gap[0] != '<' || gap[len(gap)-1] != '>' || !isHex(gap[1:len(gap)-1]) {
+				return nil, 0, errors.New("unsigned gap in byte range contains more than the signature")
+			}
+		}
+		var buf = make([]byte, length)
+		if _, err = pdf.ReadAt(buf, int64(start)); err != nil {
+			return nil, 0, err
+		}
+		signed = append(signed, buf...)
+		end = start + length
+	}
+	return signed, end, nil
+}
+
+// isHex returns whether the bytes are all hexadecimal digits.
+func isHex(by []byte) bool {
+	for _, b := range by {
+		if !(b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// modifiedSince returns the list of objects that existed in the revision of
+// the PDF ending at the specified offset, and have been changed or removed
+// since.
+func modifiedSince(pdf *pdfstruct.PDF, end int64) (modified []pdfstruct.Reference, err error) {
+	var rev *pdfstruct.PDF
+
+	if rev, err = pdf.Revision(end); err != nil {
+		return nil, fmt.Errorf("reading signed revision: %s", err)
+	}
+	for _, ref := range rev.Objects() {
+		var old, cur pdfstruct.Object
+		if old, err = rev.Get(ref); err != nil {
+			return nil, fmt.Errorf("reading signed revision: %s", err)
+		}
+		if cur, err = pdf.Get(ref); err != nil || !reflect.DeepEqual(old, cur) {
+			modified = append(modified, ref)
+		}
+	}
+	return modified, nil
+}
+
+var pdfDateRE = regexp.MustCompile(`^D:(\d{4})(\d\d)?(\d\d)?(\d\d)?(\d\d)?(\d\d)?(?:([-+Z])(?:(\d\d)'?(?:(\d\d)'?)?)?)?$`)
+
+// parsePDFDate parses a date in PDF format, D:YYYYMMDDHHmmSSOHH'mm'.  All parts
+// after the year are optional.
+func parsePDFDate(s string) (t time.Time, ok bool) {
+	var (
+		m     []string
+		parts [6]int
+		loc   = time.UTC
+	)
+	if m = pdfDateRE.FindStringSubmatch(s); m == nil {
+		return t, false
+	}
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(m[i+1])
+		if i < 3 && parts[i] == 0 {
+			parts[i] = 1 // month and day default to 1
+		}
+	}
+	if m[7] == "+" || m[7] == "-" {
+		hh, _ := strconv.Atoi(m[8])
+		mm, _ := strconv.Atoi(m[9])
+		var offset = hh*3600 + mm*60
+		if m[7] == "-" {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, loc), true
+}
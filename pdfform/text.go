@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
-	"strings"
 
 	"github.com/rothskeller/pdf/pdfstruct"
+	"github.com/rothskeller/pdf/pdftext"
 )
 
 /*
@@ -64,78 +64,98 @@ Kids array mapping to multiple annotation dictionaries containing the rest of
 the fields.
 */
 
-// setText sets the value of a text field in a form.  fontsize is the font size
-// to use; it is required for fields that do not have a font size supplied in
-// the PDF, and ignored otherwise.
-func setText(
-	pdf *pdfstruct.PDF, form, field pdfstruct.Dict, fieldref pdfstruct.Reference, value string, fontSize float64,
-) (err error) {
+// setText sets the value of a text field in a form.  opts.FontSize is the font
+// size to use for fields that do not have a font size supplied in the PDF (if
+// it is zero, a size that fits the field height is used), and ignored
+// otherwise.  opts.FontFallback says what to do if the
+// field's font is not defined in the form.
+func setText(pdf *pdfstruct.PDF, form pdfstruct.Dict, field *fieldNode, value string, opts Options) (err error) {
+	// Apply the field's keystroke and format actions, which can reject
+	// the value, normalize it, and format it for display.
+	var display string
+	if value, display, err = textFormat(pdf, field, value); err != nil {
+		return err
+	}
 	// If the field value isn't changing, we don't need to do anything.
-	if curr, ok := field["V"].(string); ok && curr == value {
+	if curr, ok := field.dict["V"].(string); ok && decodeText(curr) == value {
 		return nil
 	}
-	// Update the field value and save it.
-	field["V"] = value
-	pdf.UpdateObject(fieldref, field)
-	// Look up the font name and size from the default field appearance.
-	var fontName string
-	if fontName, fontSize, err = textFontNameSize(pdf, field, fontSize); err != nil {
+	// Look up the font name and size from the default field appearance,
+	// and find the font dictionary.
+	var (
+		fontName string
+		fontSize float64
+		fontRef  pdfstruct.Reference
+		found    bool
+		needAP   bool // set AcroForm[NeedAppearances] too
+	)
+	if fontName, fontSize, err = textFontNameSize(pdf, form, field.inherited("DA"), opts.FontSize); err != nil {
 		return err
 	}
-	// Find the font dictionary.
-	var fontRef pdfstruct.Reference
-	if fontRef, err = textResourcesFont(pdf, form, fontName); err != nil {
-		return err
-	}
-	// Get the list of the annotation widgets for the field.  (Usually there
-	// is only one, but sometimes there are more.)
-	var kids pdfstruct.Array
-	switch a := field["Kids"].(type) {
-	case nil:
-		kids = append(kids, fieldref)
-	case pdfstruct.Reference:
-		if kids, err = pdf.GetArray(a); err != nil {
-			return fmt.Errorf("field[Kids]: %s", err)
+	if fontName != "" {
+		if fontRef, found, err = textResourcesFont(pdf, form, fontName); err != nil {
+			return err
 		}
-	case pdfstruct.Array:
-		kids = a
-	default:
-		return errors.New("field[Kids] is not an Array")
 	}
-	// Loop over the list and update each of them.
-	for i, k := range kids {
-		// Get the widget dictionary and its reference.
-		var kid pdfstruct.Dict
-		var kidref pdfstruct.Reference
-		if k == fieldref {
-			kid, kidref = field, fieldref
-		} else {
-			switch k := k.(type) {
-			case pdfstruct.Reference:
-				if kid, err = pdf.GetDict(k); err != nil {
-					return fmt.Errorf("field[Kids][%d]: %s", i, err)
-				}
-				kidref = k
-			default:
-				return fmt.Errorf("field[Kids][%d] is not a Reference", i)
+	if !found {
+		switch opts.FontFallback {
+		case FallbackNeedAppearances, FallbackHelvetica:
+			if fontRef, err = textHelvetica(pdf, form); err != nil {
+				return err
 			}
+			fontName, needAP = "Helv", opts.FontFallback == FallbackNeedAppearances
+		default:
+			if fontName == "" {
+				return errors.New("field[DA] does not contain a font setting")
+			}
+			return fmt.Errorf("field[DA] references font %q which is not defined in AcroForm[DR][Font]", fontName)
+		}
+	}
+	// Update the field value and save it.
+	field.dict["V"] = encodeText(value)
+	field.update(pdf)
+	// Loop over the list of annotation widgets for the field (usually
+	// there is only one, but sometimes there are more) and update each of
+	// them.
+	for i, kid := range field.widgets {
+		if kid.ref.Number == 0 {
+			return fmt.Errorf("field[Kids][%d] is not a Reference", i)
 		}
 		// Compute the bounding box for the widget.
 		var bbox []float64
 		var bboxa pdfstruct.Array
-		if bbox, bboxa, err = textBBox(pdf, kidref, kid); err != nil {
+		if bbox, bboxa, err = textBBox(pdf, kid.ref, kid.dict); err != nil {
 			return fmt.Errorf("field[Kids][%d]: %s", i, err)
 		}
-		// Compute the content stream for the widget.
-		var cstream = textCStream(bbox, value, fontName, fontSize)
+		// Compute the content stream for the widget.  If we don't have a
+		// font size, pick one that fits the widget height.
+		var quadding, _ = field.inherited("Q").(int)
+		var size = newFieldFontSize(fontSize, [4]float64{0, 0, bbox[2], bbox[3]})
+		var cstream = textCStream(bbox, display, fontName, textBaseFont(pdf, fontRef), size, quadding)
 		// Compute the appearance for the field and save it.
-		if err = textAPN(pdf, kidref, kid, bboxa, value, fontName, fontRef, cstream); err != nil {
+		if err = textAPN(pdf, kid.ref, kid.dict, bboxa, display, fontName, fontRef, cstream); err != nil {
 			return fmt.Errorf("field[Kids][%d]: %s", i, err)
 		}
 	}
+	if needAP {
+		return textNeedAppearances(pdf, form)
+	}
 	return nil
 }
 
+// textNeedAppearances sets AcroForm[NeedAppearances], so that the PDF reader
+// will regenerate the appearances of the fields.  It is used for text fields
+// whose font is not defined in the form; they are given a basic Helvetica
+// appearance as well, for readers that ignore NeedAppearances and for
+// flattening.
+func textNeedAppearances(pdf *pdfstruct.PDF, form pdfstruct.Dict) (err error) {
+	if na, ok := form["NeedAppearances"].(bool); ok && na {
+		return nil
+	}
+	form["NeedAppearances"] = true
+	return updateForm(pdf, form)
+}
+
 // textBBox computes the bounding box for the field appearance XObject.
 func textBBox(
 	pdf *pdfstruct.PDF, widgetref pdfstruct.Reference, widget pdfstruct.Dict,
@@ -181,14 +201,20 @@ func textBBox(
 
 var textDAFontRE = regexp.MustCompile(`/(\S+)\s*([0-9]+(?:\.[0-9]*)?)\s*Tf\b`)
 
-// textFontNameSize returns the font name and size from the default appearance of the
-// field.  If the font size is not specified there, it returns the supplied font
-// size.
-func textFontNameSize(pdf *pdfstruct.PDF, field pdfstruct.Dict, defaultSize float64) (name string, size float64, err error) {
+// textFontNameSize returns the font name and size from the default appearance
+// of the field (daobj), or of the form if the field doesn't have one.  If the
+// font size is not specified there, it returns the supplied font size.  If no
+// font is specified there, it returns an empty name.
+func textFontNameSize(
+	pdf *pdfstruct.PDF, form pdfstruct.Dict, daobj pdfstruct.Object, defaultSize float64,
+) (name string, size float64, err error) {
 	var da string
-	switch a := field["DA"].(type) {
+	if daobj == nil {
+		daobj = form["DA"]
+	}
+	switch a := daobj.(type) {
 	case nil:
-		return "", 0, errors.New("field[DA] is not set")
+		return "", defaultSize, nil
 	case pdfstruct.Reference: // hardly seems likely, but it's allowed
 		if da, err = pdf.GetString(a); err != nil {
 			return "", 0, fmt.Errorf("field[DA]: %s", err)
@@ -200,7 +226,7 @@ func textFontNameSize(pdf *pdfstruct.PDF, field pdfstruct.Dict, defaultSize floa
 	}
 	var match []string
 	if match = textDAFontRE.FindStringSubmatch(da); match == nil {
-		return "", 0, errors.New("field[DA] does not contain a font setting")
+		return "", defaultSize, nil
 	}
 	name = match[1]
 	size, _ = strconv.ParseFloat(match[2], 64)
@@ -210,105 +236,159 @@ func textFontNameSize(pdf *pdfstruct.PDF, field pdfstruct.Dict, defaultSize floa
 	return name, size, nil
 }
 
-// textResourcesFont returns the font dictionary for the named font.
-func textResourcesFont(pdf *pdfstruct.PDF, form pdfstruct.Dict, fontName string) (ref pdfstruct.Reference, err error) {
+// textResourcesFont returns the font dictionary for the named font.  It returns
+// found=false if the font is not defined in the form.
+func textResourcesFont(
+	pdf *pdfstruct.PDF, form pdfstruct.Dict, fontName string,
+) (ref pdfstruct.Reference, found bool, err error) {
 	var dr pdfstruct.Dict
 	switch a := form["DR"].(type) {
 	case nil:
-		return ref, errors.New("AcroForm[DR] is not present")
+		return ref, false, nil
 	case pdfstruct.Reference:
 		if dr, err = pdf.GetDict(a); err != nil {
-			return ref, fmt.Errorf("AcroForm[DR]: %s", err)
+			return ref, false, fmt.Errorf("AcroForm[DR]: %s", err)
 		}
 	case pdfstruct.Dict:
 		dr = a
 	default:
-		return ref, errors.New("AcroForm[DR] is not a Dict")
+		return ref, false, errors.New("AcroForm[DR] is not a Dict")
 	}
 	var font pdfstruct.Dict
 	switch a := dr["Font"].(type) {
 	case nil:
-		return ref, errors.New("AcroForm[DR][Font] is not present")
+		return ref, false, nil
 	case pdfstruct.Reference:
 		if font, err = pdf.GetDict(a); err != nil {
-			return ref, fmt.Errorf("AcroForm[DR][Font]: %s", err)
+			return ref, false, fmt.Errorf("AcroForm[DR][Font]: %s", err)
 		}
 	case pdfstruct.Dict:
 		font = a
 	default:
-		return ref, errors.New("AcroForm[DR][Font] is not a Dict")
+		return ref, false, errors.New("AcroForm[DR][Font] is not a Dict")
 	}
 	switch a := font[pdfstruct.Name(fontName)].(type) {
 	case nil:
-		return ref, fmt.Errorf("field[DA] references font %q which is not defined in AcroForm[DR][Font]", fontName)
+		return ref, false, nil
 	case pdfstruct.Reference:
-		return a, nil
+		return a, true, nil
 	default:
-		return ref, fmt.Errorf("AcroForm[DR][Form][%s] is not a Reference", fontName)
+		return ref, false, fmt.Errorf("AcroForm[DR][Font][%s] is not a Reference", fontName)
 	}
 }
 
-// Ideally we would compute line placement based on actual font metrics, but
-// that's hard.  For now, we'll hard-code a font ratio and hope it's good
-// enough for our usage.
-const ascenderToTotalRatio = 0.8
+// textHelvetica returns a reference to the font dictionary for /Helv in the
+// form's default resources, adding a standard Helvetica font there if /Helv
+// isn't already defined.
+func textHelvetica(pdf *pdfstruct.PDF, form pdfstruct.Dict) (ref pdfstruct.Reference, err error) {
+	return formFont(pdf, form, "Helv", pdfstruct.Dict{
+		"Type":     pdfstruct.Name("Font"),
+		"Subtype":  pdfstruct.Name("Type1"),
+		"BaseFont": pdfstruct.Name("Helvetica"),
+		"Encoding": pdfstruct.Name("WinAnsiEncoding"),
+	})
+}
 
-func textCStream(bbox []float64, value, fontName string, fontSize float64) []byte {
+// formFont returns a reference to the font dictionary for the named font in
+// the form's default resources, adding the supplied font dictionary there if
+// the name isn't already defined.
+func formFont(pdf *pdfstruct.PDF, form pdfstruct.Dict, name string, fontDict pdfstruct.Dict) (ref pdfstruct.Reference, err error) {
+	var (
+		dr      pdfstruct.Dict
+		drref   pdfstruct.Reference
+		font    pdfstruct.Dict
+		fontref pdfstruct.Reference
+		found   bool
+	)
+	if ref, found, err = textResourcesFont(pdf, form, name); err != nil || found {
+		return ref, err
+	}
+	switch a := form["DR"].(type) {
+	case nil:
+		dr = make(pdfstruct.Dict)
+		form["DR"] = dr
+	case pdfstruct.Reference:
+		if dr, err = pdf.GetDict(a); err != nil {
+			return ref, fmt.Errorf("AcroForm[DR]: %s", err)
+		}
+		drref = a
+	case pdfstruct.Dict:
+		dr = a
+	}
+	switch a := dr["Font"].(type) {
+	case nil:
+		font = make(pdfstruct.Dict)
+		dr["Font"] = font
+	case pdfstruct.Reference:
+		if font, err = pdf.GetDict(a); err != nil {
+			return ref, fmt.Errorf("AcroForm[DR][Font]: %s", err)
+		}
+		fontref = a
+	case pdfstruct.Dict:
+		font = a
+	}
+	ref = pdf.CreateObject(fontDict)
+	font[pdfstruct.Name(name)] = ref
+	switch {
+	case fontref.Number != 0:
+		pdf.UpdateObject(fontref, font)
+	case drref.Number != 0:
+		pdf.UpdateObject(drref, dr)
+	default:
+		if err = updateForm(pdf, form); err != nil {
+			return ref, err
+		}
+	}
+	return ref, nil
+}
+
+// textQuadding maps the values of a field's quadding (Q) to text alignments.
+var textQuadding = map[int]string{0: "left", 1: "center", 2: "right"}
+
+// textMinFontSize is the smallest size to which text is shrunk to fit in its
+// field.
+const textMinFontSize = 6
+
+// textCStream returns the content stream for the appearance of a text field
+// with the specified bounding box and value.  The value is laid out with
+// pdftext, using the metrics of baseFont; it is aligned according to the
+// field's quadding, wrapped if the field has room for more than one line, and
+// shrunk if needed to fit.
+func textCStream(bbox []float64, value, fontName, baseFont string, fontSize float64, quadding int) []byte {
 	var buf bytes.Buffer
-	var multiline = bbox[3] >= (2.35*fontSize + 4) // room for two lines or more
-	var leading = fontSize * 1.2
-	var topline float64
-	if multiline {
-		// The initial vertical position of the text is 2 units plus an
-		// ascender down from the top of the bounding box.
-		topline = bbox[3] - 2.0 - ascenderToTotalRatio*fontSize
-	} else {
-		// The initial vertical position has the text centered in the
-		// bounding box.  (It will look a little above the center if it
-		// does not start with something having a descender.)
-		topline = bbox[3]/2 + fontSize/2 - ascenderToTotalRatio*fontSize
+	var style = pdftext.Style{
+		Font: baseFont, FontSize: fontSize, MinFontSize: textMinFontSize, LineHeight: 1.2,
+		Color: []byte{0, 0, 153}, HAlign: textQuadding[quadding], VAlign: "center",
+	}
+	if bbox[3] >= (2.35*fontSize + 4) { // room for two lines or more
+		style.VAlign, style.Wrap = "top", 1
 	}
 	// Start the rendering instructions.  Translation: begin marked content
 	// for /Tx; save graphics state; define a rectangular path inset one
 	// unit from the bounding box; set it as the clipping path; drop the
-	// path; begin text object; set the font; set a dark blue color; set the
-	// initial text position.
-	fmt.Fprintf(&buf, "/Tx BMC q 1 1 %f %f re W n BT /%s %f Tf 0 0 0.6 rg %f TL 2 %f Td ",
-		bbox[2]-2.0, bbox[3]-2.0, fontName, fontSize, leading, topline)
-	// Emit the text itself.
-	if multiline {
-		for _, line := range strings.Split(value, "\n") {
-			fmt.Fprintf(&buf, "%s Tj T* ", encodeString(line))
-		}
-	} else {
-		fmt.Fprintf(&buf, "%s Tj ", encodeString(value))
-	}
+	// path.
+	fmt.Fprintf(&buf, "/Tx BMC q 1 1 %f %f re W n\n", bbox[2]-2.0, bbox[3]-2.0)
+	// Emit the text itself, inset two units from the bounding box.
+	pdftext.DrawContent(&buf, []pdftext.Span{{Text: value}}, 2, 2, bbox[2]-4, bbox[3]-4, style,
+		map[string]string{baseFont: fontName})
 	// Finish the rendering instructions.
-	buf.WriteString("ET Q EMC\n")
+	buf.WriteString("Q EMC\n")
 	return buf.Bytes()
 }
 
-// encodeString encodes the string in PDF syntax.  CRs, backslashes, and
-// parentheses are escaped; everything else is literal; the whole is surrounded
-// in parentheses.
-func encodeString(s string) string {
-	var sb strings.Builder
-	var by = []byte(s)
-	sb.WriteByte('(')
-	for _, b := range by {
-		switch b {
-		case '\r':
-			sb.WriteByte('\\')
-			sb.WriteByte('r')
-		case '\\', '(', ')':
-			sb.WriteByte('\\')
-			sb.WriteByte(b)
-		default:
-			sb.WriteByte(b)
+// textBaseFont returns the name of the font with the specified font dictionary,
+// for looking up its metrics in pdftext.  If the font isn't one that pdftext
+// has metrics for, it returns Helvetica, whose metrics are a reasonable
+// approximation.
+func textBaseFont(pdf *pdfstruct.PDF, fontRef pdfstruct.Reference) string {
+	if font, err := pdf.GetDict(fontRef); err == nil {
+		if name, ok := font["BaseFont"].(pdfstruct.Name); ok {
+			if ha, _ := pdftext.FontMetrics(string(name), 1); ha != 0 {
+				return string(name)
+			}
 		}
 	}
-	sb.WriteByte(')')
-	return sb.String()
+	return "Helvetica"
 }
 
 // textAPN computes and saves the appearance of a text field.
@@ -0,0 +1,42 @@
+package pdfform
+
+import (
+	"unicode/utf16"
+)
+
+// encodeText encodes a string as a PDF text string.  Strings that are entirely
+// ASCII are left alone; anything else is encoded in UTF-16BE with a leading
+// byte order mark.
+func encodeText(s string) string {
+	var ascii = true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+	var units = utf16.Encode([]rune(s))
+	var by = make([]byte, 2, 2+2*len(units))
+	by[0], by[1] = 0xFE, 0xFF
+	for _, u := range units {
+		by = append(by, byte(u>>8), byte(u))
+	}
+	return string(by)
+}
+
+// decodeText decodes a PDF text string.  Text strings are either UTF-16BE with
+// a leading byte order mark, or in PDFDocEncoding, which we treat as if it
+// were the same as the string's (usually ASCII) bytes.
+func decodeText(s string) string {
+	if len(s) < 2 || s[0] != 0xFE || s[1] != 0xFF {
+		return s
+	}
+	var units = make([]uint16, 0, len(s)/2-1)
+	for i := 2; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
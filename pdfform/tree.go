@@ -0,0 +1,453 @@
+package pdfform
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// A fieldNode is a node in the form field hierarchy.  It is either a field
+// (terminal or not) or a widget annotation belonging to a terminal field.  When
+// a terminal field has only one widget, the two are usually merged into a
+// single dictionary, in which case the field node lists itself as its widget.
+type fieldNode struct {
+	ref     pdfstruct.Reference // zero if the node is a direct object
+	dict    pdfstruct.Dict
+	name    string // fully qualified field name
+	parent  *fieldNode
+	kids    []*fieldNode // child fields
+	widgets []*fieldNode // widget annotations of a terminal field
+}
+
+// getFieldTree reads the form field hierarchy of the PDF.  It returns the
+// AcroForm dictionary and the top-level fields.  If the PDF has no form, it
+// returns a nil form.
+func getFieldTree(pdf *pdfstruct.PDF) (form pdfstruct.Dict, fields []*fieldNode, err error) {
+	var flist pdfstruct.Array
+
+	if form, err = dictOf(pdf, pdf.Catalog["AcroForm"]); err != nil {
+		return nil, nil, fmt.Errorf("AcroForm: %s", err)
+	}
+	if form == nil {
+		return nil, nil, nil
+	}
+	if flist, err = arrayOf(pdf, form["Fields"]); err != nil {
+		return nil, nil, fmt.Errorf("AcroForm[Fields]: %s", err)
+	}
+	for i, f := range flist {
+		var node *fieldNode
+		if node, err = getFieldNode(pdf, f, nil); err != nil {
+			return nil, nil, fmt.Errorf("AcroForm[Fields][%d]: %s", i, err)
+		}
+		fields = append(fields, node)
+	}
+	return form, fields, nil
+}
+
+// getFieldNode reads a field, and all of its descendants, from the PDF.
+func getFieldNode(pdf *pdfstruct.PDF, obj pdfstruct.Object, parent *fieldNode) (node *fieldNode, err error) {
+	var kids pdfstruct.Array
+
+	node = &fieldNode{parent: parent}
+	if node.ref, node.dict, err = refDictOf(pdf, obj); err != nil {
+		return nil, err
+	}
+	if parent != nil {
+		node.name = parent.name
+	}
+	switch t := node.dict["T"].(type) {
+	case nil:
+		break
+	case string:
+		if node.name != "" {
+			node.name += "."
+		}
+		node.name += decodeText(t)
+	default:
+		return nil, errors.New("T is not a string")
+	}
+	if kids, err = arrayOf(pdf, node.dict["Kids"]); err != nil {
+		return nil, fmt.Errorf("Kids: %s", err)
+	}
+	if len(kids) == 0 {
+		// A field with no kids is a terminal field merged with its
+		// only widget.
+		node.widgets = append(node.widgets, node)
+		return node, nil
+	}
+	for i, k := range kids {
+		var kid = &fieldNode{parent: node, name: node.name}
+		if kid.ref, kid.dict, err = refDictOf(pdf, k); err != nil {
+			return nil, fmt.Errorf("Kids[%d]: %s", i, err)
+		}
+		if _, ok := kid.dict["T"]; !ok && kid.dict["Subtype"] == pdfstruct.Name("Widget") {
+			node.widgets = append(node.widgets, kid)
+			continue
+		}
+		if kid, err = getFieldNode(pdf, k, node); err != nil {
+			return nil, fmt.Errorf("Kids[%d]: %s", i, err)
+		}
+		node.kids = append(node.kids, kid)
+	}
+	return node, nil
+}
+
+// terminal returns whether the node is a terminal field, i.e., one that has
+// widgets rather than child fields.
+func (n *fieldNode) terminal() bool {
+	return len(n.kids) == 0
+}
+
+// inherited returns the value of the specified key in the field dictionary, or
+// in the nearest ancestor that has it.
+func (n *fieldNode) inherited(key pdfstruct.Name) pdfstruct.Object {
+	for ; n != nil; n = n.parent {
+		if v, ok := n.dict[key]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// fieldType returns the (possibly inherited) field type.
+func (n *fieldNode) fieldType() pdfstruct.Name {
+	ft, _ := n.inherited("FT").(pdfstruct.Name)
+	return ft
+}
+
+// flags returns the (possibly inherited) field flags.
+func (n *fieldNode) flags() int {
+	ff, _ := n.inherited("Ff").(int)
+	return ff
+}
+
+// update saves a change to the node.  If the node is a direct object, the
+// change is saved by saving its parent.
+func (n *fieldNode) update(pdf *pdfstruct.PDF) {
+	for ; n != nil; n = n.parent {
+		if n.ref.Number != 0 {
+			pdf.UpdateObject(n.ref, n.dict)
+			return
+		}
+	}
+}
+
+// walkFields calls fn for every terminal field in the list of fields and their
+// descendants.  It stops at the first error returned by fn.
+func walkFields(fields []*fieldNode, fn func(*fieldNode) error) (err error) {
+	for _, f := range fields {
+		if f.terminal() {
+			err = fn(f)
+		} else {
+			err = walkFields(f.kids, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findField returns the field with the specified fully qualified name, or nil
+// if there is no such field.
+func findField(fields []*fieldNode, name string) *fieldNode {
+	for _, f := range fields {
+		if f.name == name {
+			return f
+		}
+		if strings.HasPrefix(name, f.name+".") {
+			if found := findField(f.kids, name); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// matchName returns whether the field name is one of the supplied names, or a
+// descendant of one of them.  An empty list of names matches everything.
+func matchName(name string, names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if name == n || strings.HasPrefix(name, n+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// removeField removes a field and all of its descendants from the form: from
+// its parent's Kids (or AcroForm[Fields]), from AcroForm[CO], and removes its
+// widgets from the Annots of the pages that hold them.  If the parent is left
+// without any kids, it is removed too.
+func removeField(pdf *pdfstruct.PDF, form pdfstruct.Dict, pages []*pageNode, n *fieldNode) (err error) {
+	var gone = make(map[pdfstruct.Reference]bool)
+	collectRefs(n, gone)
+	// Remove the widgets from the pages.
+	for _, page := range pages {
+		if err = page.removeAnnots(pdf, gone); err != nil {
+			return err
+		}
+	}
+	// Remove the fields from the calculation order.
+	if form["CO"] != nil {
+		if err = removeRefs(pdf, form, "CO", gone, func() error { return updateForm(pdf, form) }); err != nil {
+			return fmt.Errorf("AcroForm[CO]: %s", err)
+		}
+	}
+	return detachField(pdf, form, pages, n)
+}
+
+// detachField removes a field from its parent's Kids (or AcroForm[Fields]).
+// If the parent is left without any kids, it is removed from the form.
+func detachField(pdf *pdfstruct.PDF, form pdfstruct.Dict, pages []*pageNode, n *fieldNode) (err error) {
+	var gone = map[pdfstruct.Reference]bool{n.ref: true}
+	if n.parent == nil {
+		if err = removeRefs(pdf, form, "Fields", gone, func() error { return updateForm(pdf, form) }); err != nil {
+			return fmt.Errorf("AcroForm[Fields]: %s", err)
+		}
+		return nil
+	}
+	var parent = n.parent
+	if err = removeRefs(pdf, parent.dict, "Kids", gone, func() error { parent.update(pdf); return nil }); err != nil {
+		return fmt.Errorf("%s[Kids]: %s", parent.name, err)
+	}
+	for i, k := range parent.kids {
+		if k == n {
+			parent.kids = append(parent.kids[:i:i], parent.kids[i+1:]...)
+			break
+		}
+	}
+	for i, w := range parent.widgets {
+		if w == n {
+			parent.widgets = append(parent.widgets[:i:i], parent.widgets[i+1:]...)
+			break
+		}
+	}
+	if len(parent.kids) == 0 && len(parent.widgets) == 0 {
+		return removeField(pdf, form, pages, parent)
+	}
+	return nil
+}
+
+// addField adds a field to the form, as a kid of parent, or as a top-level
+// field if parent is nil.  The field's Parent entry must already be set.
+func addField(pdf *pdfstruct.PDF, form pdfstruct.Dict, parent *fieldNode, n *fieldNode) (err error) {
+	if parent == nil {
+		if err = appendRef(pdf, form, "Fields", n.ref, func() error { return updateForm(pdf, form) }); err != nil {
+			return fmt.Errorf("AcroForm[Fields]: %s", err)
+		}
+		return nil
+	}
+	if err = appendRef(pdf, parent.dict, "Kids", n.ref, func() error { parent.update(pdf); return nil }); err != nil {
+		return fmt.Errorf("%s[Kids]: %s", parent.name, err)
+	}
+	parent.kids = append(parent.kids, n)
+	return nil
+}
+
+// collectRefs adds the references of a node and all of its descendants to the
+// map.
+func collectRefs(n *fieldNode, refs map[pdfstruct.Reference]bool) {
+	if n.ref.Number != 0 {
+		refs[n.ref] = true
+	}
+	for _, w := range n.widgets {
+		if w.ref.Number != 0 {
+			refs[w.ref] = true
+		}
+	}
+	for _, k := range n.kids {
+		collectRefs(k, refs)
+	}
+}
+
+// removeRefs removes the specified references from the array stored under key
+// in dict.  If the array is a separate object, it is saved; otherwise save is
+// called to save dict.
+func removeRefs(
+	pdf *pdfstruct.PDF, dict pdfstruct.Dict, key pdfstruct.Name, refs map[pdfstruct.Reference]bool, save func() error,
+) (err error) {
+	var (
+		list    pdfstruct.Array
+		listref pdfstruct.Reference
+		keep    pdfstruct.Array
+	)
+	switch a := dict[key].(type) {
+	case nil:
+		return nil
+	case pdfstruct.Reference:
+		if list, err = pdf.GetArray(a); err != nil {
+			return err
+		}
+		listref = a
+	case pdfstruct.Array:
+		list = a
+	default:
+		return errors.New("not an Array")
+	}
+	for _, o := range list {
+		if r, ok := o.(pdfstruct.Reference); !ok || !refs[r] {
+			keep = append(keep, o)
+		}
+	}
+	if len(keep) == len(list) {
+		return nil
+	}
+	if keep == nil {
+		keep = pdfstruct.Array{}
+	}
+	if listref.Number != 0 {
+		pdf.UpdateObject(listref, keep)
+		return nil
+	}
+	dict[key] = keep
+	return save()
+}
+
+// replaceRef replaces a reference in the array stored under key in dict, if it
+// is there.  If the array is a separate object, it is saved; otherwise save is
+// called to save dict.
+func replaceRef(
+	pdf *pdfstruct.PDF, dict pdfstruct.Dict, key pdfstruct.Name, old, ref pdfstruct.Reference, save func() error,
+) (err error) {
+	var (
+		list    pdfstruct.Array
+		listref pdfstruct.Reference
+		found   bool
+	)
+	switch a := dict[key].(type) {
+	case nil:
+		return nil
+	case pdfstruct.Reference:
+		if list, err = pdf.GetArray(a); err != nil {
+			return err
+		}
+		listref = a
+	case pdfstruct.Array:
+		list = a
+	default:
+		return errors.New("not an Array")
+	}
+	for i, o := range list {
+		if o == old {
+			list[i], found = ref, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	if listref.Number != 0 {
+		pdf.UpdateObject(listref, list)
+		return nil
+	}
+	return save()
+}
+
+// appendRef appends a reference to the array stored under key in dict,
+// creating the array if needed.  If the array is a separate object, it is
+// saved; otherwise save is called to save dict.
+func appendRef(
+	pdf *pdfstruct.PDF, dict pdfstruct.Dict, key pdfstruct.Name, ref pdfstruct.Reference, save func() error,
+) (err error) {
+	switch a := dict[key].(type) {
+	case nil:
+		dict[key] = pdfstruct.Array{ref}
+	case pdfstruct.Reference:
+		var list pdfstruct.Array
+		if list, err = pdf.GetArray(a); err != nil {
+			return err
+		}
+		pdf.UpdateObject(a, append(list, ref))
+		return nil
+	case pdfstruct.Array:
+		dict[key] = append(a, ref)
+	default:
+		return errors.New("not an Array")
+	}
+	return save()
+}
+
+// dictOf returns the Dict that obj is or refers to.  It returns nil if obj is
+// nil.
+func dictOf(pdf *pdfstruct.PDF, obj pdfstruct.Object) (dict pdfstruct.Dict, err error) {
+	switch o := obj.(type) {
+	case nil:
+		return nil, nil
+	case pdfstruct.Reference:
+		return pdf.GetDict(o)
+	case pdfstruct.Dict:
+		return o, nil
+	default:
+		return nil, errors.New("not a Dict")
+	}
+}
+
+// refDictOf returns the Dict that obj is or refers to, along with the reference
+// if there is one.
+func refDictOf(pdf *pdfstruct.PDF, obj pdfstruct.Object) (ref pdfstruct.Reference, dict pdfstruct.Dict, err error) {
+	switch o := obj.(type) {
+	case pdfstruct.Reference:
+		dict, err = pdf.GetDict(o)
+		return o, dict, err
+	case pdfstruct.Dict:
+		return ref, o, nil
+	default:
+		return ref, nil, errors.New("not a Dict")
+	}
+}
+
+// arrayOf returns the Array that obj is or refers to.  It returns nil if obj is
+// nil.
+func arrayOf(pdf *pdfstruct.PDF, obj pdfstruct.Object) (array pdfstruct.Array, err error) {
+	switch o := obj.(type) {
+	case nil:
+		return nil, nil
+	case pdfstruct.Reference:
+		return pdf.GetArray(o)
+	case pdfstruct.Array:
+		return o, nil
+	default:
+		return nil, errors.New("not an Array")
+	}
+}
+
+// numberOf returns the number that obj is or refers to.
+func numberOf(pdf *pdfstruct.PDF, obj pdfstruct.Object) (num float64, err error) {
+	if ref, ok := obj.(pdfstruct.Reference); ok {
+		if obj, err = pdf.Get(ref); err != nil {
+			return 0, err
+		}
+	}
+	switch o := obj.(type) {
+	case int:
+		return float64(o), nil
+	case float64:
+		return o, nil
+	default:
+		return 0, errors.New("not a number")
+	}
+}
+
+// rectOf returns the rectangle (or other array of four numbers) that obj is or
+// refers to.
+func rectOf(pdf *pdfstruct.PDF, obj pdfstruct.Object) (rect []float64, err error) {
+	var a pdfstruct.Array
+	if a, err = arrayOf(pdf, obj); err != nil {
+		return nil, err
+	}
+	if len(a) != 4 {
+		return nil, errors.New("not an Array of length 4")
+	}
+	rect = make([]float64, 4)
+	for i, v := range a {
+		if rect[i], err = numberOf(pdf, v); err != nil {
+			return nil, errors.New("not an Array of 4 numbers")
+		}
+	}
+	return rect, nil
+}
@@ -0,0 +1,163 @@
+package pdfform
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+// A Problem describes a problem with a field value, found by Validate.
+type Problem struct {
+	// Field is the fully qualified name of the field.
+	Field string
+	// Message describes the problem.
+	Message string
+}
+
+func (p Problem) String() string { return p.Field + ": " + p.Message }
+
+// Field flags common to all field types.
+const (
+	fieldReadOnly = 1 << 0
+	fieldRequired = 1 << 1
+)
+
+// Validate checks a set of field values against the constraints of the form,
+// without changing the PDF.  values is a map from fully qualified field name
+// to the value that would be passed to SetField; fields not in the map are
+// assumed to keep their current values.  Validate returns every problem it
+// finds: names that aren't terminal fields of the form, required fields that
+// would be empty, text values longer than the field's MaxLen or rejected by its
+// keystroke action, choices not among the field's options, invalid button
+// states, and changes to read-only fields and to fields locked by signatures.
+// Problems are returned in form order, followed by problems with unknown field
+// names in name order.  The error return is used only for failures to read the
+// form.
+func Validate(pdf *pdfstruct.PDF, values map[string]string) (problems []Problem, err error) {
+	var (
+		fields []*fieldNode
+		seen   = make(map[string]bool)
+		locks  []fieldLock
+		perm   int
+	)
+	if _, fields, err = getFieldTree(pdf); err != nil {
+		return nil, err
+	}
+	if locks, perm, err = getLocks(pdf, fields); err != nil {
+		return nil, err
+	}
+	err = walkFields(fields, func(f *fieldNode) (err error) {
+		var msgs []string
+		value, ok := values[f.name]
+		seen[f.name] = true
+		if msgs, err = validateField(pdf, f, value, ok, fieldLockError(f, locks, perm)); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		for _, msg := range msgs {
+			problems = append(problems, Problem{Field: f.name, Message: msg})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for name := range values {
+		if !seen[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		if f := findField(fields, name); f != nil {
+			problems = append(problems, Problem{Field: name, Message: "not a terminal field"})
+		} else {
+			problems = append(problems, Problem{Field: name, Message: "no such field in form"})
+		}
+	}
+	return problems, nil
+}
+
+// validateField checks the value of a single terminal field.  set indicates
+// whether a new value is being given; if not, the field's current value is
+// checked.  lock is the error, if any, that the field's signature locks would
+// give for a change to it.  It returns a list of problem descriptions.
+func validateField(pdf *pdfstruct.PDF, field *fieldNode, value string, set bool, lock error) (msgs []string, err error) {
+	var (
+		current = valueString(field)
+		flags   = field.flags()
+		kind    = fieldKind(field)
+	)
+	if !set {
+		value = current
+	}
+	switch kind {
+	case "checkbox", "radio":
+		var states []string
+		if states, err = buttonStates(pdf, field); err != nil {
+			return nil, err
+		}
+		if current == "" {
+			current = "Off"
+		}
+		if value == "" {
+			value = "Off"
+		}
+		if value == "Yes" && kind == "checkbox" && len(states) > 1 {
+			value = states[1]
+		}
+		if set && !slices.Contains(states, value) && !(kind == "checkbox" && value == "Yes") {
+			msgs = append(msgs, fmt.Sprintf("value %q is not valid", value))
+		}
+		if value == "Off" && flags&fieldRequired != 0 {
+			msgs = append(msgs, "required field is not set")
+		}
+	case "text":
+		if set {
+			if stored, _, err := textFormat(pdf, field, value); err != nil {
+				msgs = append(msgs, err.Error())
+			} else {
+				value = stored
+			}
+			if maxlen, ok := field.inherited("MaxLen").(int); ok && utf8.RuneCountInString(value) > maxlen {
+				msgs = append(msgs, fmt.Sprintf("value is longer than %d characters", maxlen))
+			}
+		}
+		if value == "" && flags&fieldRequired != 0 {
+			msgs = append(msgs, "required field is empty")
+		}
+	case "combo", "list":
+		if set && value != "" && flags&choiceEdit == 0 {
+			var opts []string
+			if opts, err = choiceOptions(pdf, field); err != nil {
+				return nil, err
+			}
+			if !slices.Contains(opts, value) {
+				msgs = append(msgs, fmt.Sprintf("value %q is not one of the field's options", value))
+			}
+		}
+		if value == "" && flags&fieldRequired != 0 {
+			msgs = append(msgs, "required field is empty")
+		}
+	case "pushbutton":
+		if set {
+			msgs = append(msgs, "field is a push button and doesn't have a value")
+		}
+		return msgs, nil
+	default:
+		if set {
+			msgs = append(msgs, fmt.Sprintf("field type %q is not supported", field.fieldType()))
+		}
+		return msgs, nil
+	}
+	if set && value != current && flags&fieldReadOnly != 0 {
+		msgs = append(msgs, "field is read-only")
+	}
+	if set && value != current && lock != nil {
+		msgs = append(msgs, lock.Error())
+	}
+	return msgs, nil
+}
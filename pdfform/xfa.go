@@ -0,0 +1,429 @@
+package pdfform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rothskeller/pdf/pdfstruct"
+)
+
+/*
+XFA forms carry an XML description of the form in AcroForm[XFA], either as a
+single stream containing the whole XDP document, or as an array of packet
+names and streams:
+    /Root/AcroForm/XFA = Array[
+        [0] = "preamble"	[1] = (#50,0) -> Stream	[<xdp:xdp ...>]
+        [2] = "template"	[3] = (#51,0) -> Stream	[<template ...>...</template>]
+        [4] = "datasets"	[5] = (#52,0) -> Stream	[<xfa:datasets ...>...</xfa:datasets>]
+        ...
+        [n] = "postamble"	[n+1] = (#59,0) -> Stream	[</xdp:xdp>]
+    ]
+The template describes the form as a tree of subforms and fields:
+    <template>
+      <subform name="form1">
+        <subform>				[unnamed subform]
+          <field name="Name">...</field>
+          <field name="Zip"><bind match="dataRef" ref="$.Address.Zip"/></field>
+        </subform>
+      </subform>
+    </template>
+and the datasets packet holds the field values:
+    <xfa:datasets>
+      <xfa:data>
+        <form1><Name>Jane</Name><Address><Zip>94040</Zip></Address></form1>
+      </xfa:data>
+    </xfa:datasets>
+Static XFA forms also have AcroForm fields, whose names are the SOM
+expressions of the template fields: "form1[0].#subform[0].Name[0]".  Readers
+that understand XFA show the values in the datasets packet, so it must be kept
+in sync with the AcroForm field values.
+
+By default, a named subform or field binds to the data element with the same
+name (and occurrence index) in the data scope of its parent; unnamed subforms
+don't change the data scope.  <bind match="none"> disables the binding, and
+<bind match="dataRef" ref="..."> gives an explicit path relative to the parent
+scope ($), the data record ($record), or xfa:data ($data).
+*/
+
+// An xfaForm is the parsed XFA description of a form.
+type xfaForm struct {
+	template *xmlNode // the template element
+	datasets *xmlNode // the xfa:datasets element
+	data     *xmlNode // the xfa:data element
+	// The datasets packet, for saving changes.  doc is the parsed content
+	// of the stream containing it.
+	dsRef  pdfstruct.Reference
+	dsDict pdfstruct.Dict
+	dsDoc  *xmlNode
+}
+
+// An xfaStep is one step in a path through the XFA data.
+type xfaStep struct {
+	name  string
+	index int
+}
+
+// An xfaBinding connects an XFA field, named by its SOM expression, with a
+// node in the XFA data.
+type xfaBinding struct {
+	field string    // SOM expression, which is the AcroForm field name
+	path  []xfaStep // path from xfa:data to the data node
+	items []string  // values of the field's items (on and off values of a checkbox)
+}
+
+// HasXFA returns whether the PDF contains an XFA form.
+func HasXFA(pdf *pdfstruct.PDF) (has bool, err error) {
+	var form pdfstruct.Dict
+	if form, err = dictOf(pdf, pdf.Catalog["AcroForm"]); err != nil {
+		return false, fmt.Errorf("AcroForm: %s", err)
+	}
+	return form["XFA"] != nil, nil
+}
+
+// GetXFAData returns the XFA datasets packet of the PDF, as XML.  It returns nil
+// if the PDF does not have an XFA form with a datasets packet.
+func GetXFAData(pdf *pdfstruct.PDF) (data []byte, err error) {
+	var xfa *xfaForm
+	if xfa, err = getXFA(pdf); err != nil || xfa == nil || xfa.data == nil {
+		return nil, err
+	}
+	return xfa.datasets.bytes(), nil
+}
+
+// GetXFAFields returns a map from field name to field value for all fields of
+// the PDF's XFA form that are bound to data in its datasets packet.  The field
+// names are the SOM expressions of the XFA fields, which are the names of the
+// corresponding AcroForm fields, if any.  It returns an empty map if the PDF
+// does not have an XFA form.
+func GetXFAFields(pdf *pdfstruct.PDF) (fields map[string]string, err error) {
+	var xfa *xfaForm
+
+	fields = make(map[string]string)
+	if xfa, err = getXFA(pdf); err != nil || xfa == nil || xfa.data == nil || xfa.template == nil {
+		return fields, err
+	}
+	for _, b := range xfa.bindings() {
+		if node := xfa.dataNode(b.path, false); node != nil {
+			fields[b.field] = node.text()
+		}
+	}
+	return fields, nil
+}
+
+// StripXFA removes the XFA form from the PDF, so that PDF readers display the
+// AcroForm fields instead.  Any values that exist only in the XFA data are lost;
+// GetXFAFields and SetField can be used to copy them to the AcroForm fields
+// first.  The result is not applied until p.Write is called.
+func StripXFA(pdf *pdfstruct.PDF) (err error) {
+	var form pdfstruct.Dict
+	if form, err = dictOf(pdf, pdf.Catalog["AcroForm"]); err != nil {
+		return fmt.Errorf("AcroForm: %s", err)
+	}
+	if form["XFA"] == nil {
+		return nil
+	}
+	delete(form, "XFA")
+	if err = updateForm(pdf, form); err != nil {
+		return err
+	}
+	// Dynamic XFA forms ask the reader to render them from the template.
+	if pdf.Catalog["NeedsRendering"] != nil {
+		delete(pdf.Catalog, "NeedsRendering")
+		return updateCatalog(pdf)
+	}
+	return nil
+}
+
+// getXFA reads and parses the XFA form of the PDF.  It returns nil if the PDF
+// has no XFA form.
+func getXFA(pdf *pdfstruct.PDF) (xfa *xfaForm, err error) {
+	var form pdfstruct.Dict
+
+	if form, err = dictOf(pdf, pdf.Catalog["AcroForm"]); err != nil {
+		return nil, fmt.Errorf("AcroForm: %s", err)
+	}
+	xfa = new(xfaForm)
+	switch x := form["XFA"].(type) {
+	case nil:
+		return nil, nil
+	case pdfstruct.Reference:
+		// A single stream with the whole XDP document.
+		var doc *xmlNode
+		if doc, xfa.dsDict, err = xfaPacket(pdf, x); err != nil {
+			return nil, fmt.Errorf("AcroForm[XFA]: %s", err)
+		}
+		xfa.dsRef = x
+		for _, root := range doc.elements() {
+			if root.local() == "xdp" {
+				xfa.findPackets(root, doc)
+			}
+		}
+	case pdfstruct.Array:
+		for i := 0; i+1 < len(x); i += 2 {
+			name, _ := x[i].(string)
+			ref, ok := x[i+1].(pdfstruct.Reference)
+			if !ok || (name != "template" && name != "datasets") {
+				continue
+			}
+			var doc *xmlNode
+			var dict pdfstruct.Dict
+			if doc, dict, err = xfaPacket(pdf, ref); err != nil {
+				return nil, fmt.Errorf("AcroForm[XFA][%d]: %s", i+1, err)
+			}
+			xfa.findPackets(doc, doc)
+			if name == "datasets" {
+				xfa.dsRef, xfa.dsDict = ref, dict
+			}
+		}
+	default:
+		return nil, errors.New("AcroForm[XFA] is not a Reference or Array")
+	}
+	return xfa, nil
+}
+
+// xfaPacket reads and parses an XFA packet stream.
+func xfaPacket(pdf *pdfstruct.PDF, ref pdfstruct.Reference) (doc *xmlNode, dict pdfstruct.Dict, err error) {
+	var stream pdfstruct.Stream
+
+	if stream, err = pdf.GetStream(ref); err != nil {
+		return nil, nil, err
+	}
+	dict = make(pdfstruct.Dict, len(stream.Dict))
+	for k, v := range stream.Dict {
+		dict[k] = v
+	}
+	stream.Dict = dict
+	if err = stream.Decompress(0); err != nil {
+		return nil, nil, err
+	}
+	delete(dict, "DecodeParms")
+	if doc, err = parseXML(stream.Data); err != nil {
+		return nil, nil, err
+	}
+	return doc, dict, nil
+}
+
+// findPackets finds the template and data elements among the children of
+// parent, which came from the stream whose parsed content is doc.
+func (xfa *xfaForm) findPackets(parent, doc *xmlNode) {
+	for _, packet := range parent.elements() {
+		switch packet.local() {
+		case "template":
+			xfa.template = packet
+		case "datasets":
+			xfa.datasets, xfa.data = packet, packet.child("data", 0)
+			xfa.dsDoc = doc
+		}
+	}
+}
+
+// save writes the changed datasets packet back to the PDF.
+func (xfa *xfaForm) save(pdf *pdfstruct.PDF) {
+	pdf.UpdateObject(xfa.dsRef, pdfstruct.Stream{Dict: xfa.dsDict, Data: xfa.dsDoc.bytes()})
+}
+
+// xfaContainers are the template elements that contribute to SOM expressions.
+var xfaContainers = map[string]bool{
+	"subform": true, "subformSet": true, "area": true, "pageSet": true, "pageArea": true, "exclGroup": true,
+	"field": true,
+}
+
+// bindings returns the data bindings of the fields in the template.
+func (xfa *xfaForm) bindings() (bindings []xfaBinding) {
+	var record []xfaStep
+	if elems := xfa.data.elements(); len(elems) != 0 {
+		record = []xfaStep{{elems[0].local(), 0}}
+	}
+	var walk func(node *xmlNode, som string, scope []xfaStep)
+	walk = func(node *xmlNode, som string, scope []xfaStep) {
+		var counts = make(map[string]int)
+		for _, kid := range node.elements() {
+			var (
+				class = kid.local()
+				name  = kid.attr("name")
+				seg   string
+			)
+			if !xfaContainers[class] {
+				continue
+			}
+			if class == "subformSet" {
+				// Subform sets are transparent.
+				walk(kid, som, scope)
+				continue
+			}
+			if name != "" {
+				seg = fmt.Sprintf("%s[%d]", name, counts[name])
+				counts[name]++
+			} else {
+				seg = fmt.Sprintf("#%s[%d]", class, counts["#"+class])
+				counts["#"+class]++
+			}
+			var ksom = seg
+			if som != "" {
+				ksom = som + "." + seg
+			}
+			var kscope = scope
+			var bound bool
+			if class == "subform" || class == "field" || class == "exclGroup" {
+				var bind = kid.child("bind", 0)
+				var match = "once"
+				if bind != nil && bind.attr("match") != "" {
+					match = bind.attr("match")
+				}
+				switch {
+				case match == "dataRef" && bind.attr("ref") != "":
+					kscope, bound = resolveXFARef(bind.attr("ref"), scope, record), true
+				case match == "once" && name != "":
+					kscope, bound = appendStep(scope, xfaStep{name, counts[name] - 1}), true
+				case match == "global" && name != "":
+					kscope, bound = []xfaStep{{name, 0}}, true
+				}
+			}
+			switch class {
+			case "field", "exclGroup":
+				if bound && kscope != nil {
+					bindings = append(bindings, xfaBinding{field: ksom, path: kscope, items: xfaItems(kid)})
+				}
+			default:
+				if !bound {
+					kscope = scope
+				}
+				walk(kid, ksom, kscope)
+			}
+		}
+	}
+	walk(xfa.template, "", nil)
+	return bindings
+}
+
+// appendStep returns a new path with the step appended.
+func appendStep(path []xfaStep, step xfaStep) []xfaStep {
+	return append(path[:len(path):len(path)], step)
+}
+
+var xfaRefStepRE = regexp.MustCompile(`^([^\[\]]+)(?:\[(\d+|\*)\])?$`)
+
+// resolveXFARef resolves a data reference ("$.a.b", "$record.a[1]", or
+// "$data.a") to a path from xfa:data.  It returns nil if the reference isn't
+// understood.
+func resolveXFARef(ref string, scope, record []xfaStep) (path []xfaStep) {
+	var steps = strings.Split(ref, ".")
+	switch steps[0] {
+	case "$":
+		path = scope
+	case "$record":
+		path = record
+	case "$data":
+		path = nil
+	default:
+		return nil
+	}
+	for _, s := range steps[1:] {
+		var m = xfaRefStepRE.FindStringSubmatch(s)
+		if m == nil {
+			return nil
+		}
+		index, _ := strconv.Atoi(m[2]) // "*" and "" become 0
+		path = appendStep(path, xfaStep{m[1], index})
+	}
+	return path
+}
+
+// xfaItems returns the values of the items of a template field, which for a
+// checkbox are its on and off values.
+func xfaItems(field *xmlNode) (items []string) {
+	if list := field.child("items", 0); list != nil {
+		for _, item := range list.elements() {
+			items = append(items, item.text())
+		}
+	}
+	return items
+}
+
+// dataNode returns the data node at the specified path.  If create is true,
+// missing nodes are created; otherwise nil is returned if the node doesn't
+// exist.
+func (xfa *xfaForm) dataNode(path []xfaStep, create bool) (node *xmlNode) {
+	node = xfa.data
+	for _, step := range path {
+		var next = node.child(step.name, step.index)
+		for next == nil && create {
+			node.kids = append(node.kids, &xmlNode{name: step.name})
+			next = node.child(step.name, step.index)
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// An xfaSync keeps the XFA data of a PDF, if it has any, in sync with changes
+// to its AcroForm fields.  The XFA form is read when it is first needed, and
+// the changed data is written back to the PDF by save, so that a batch of
+// changes reads and writes it only once.
+type xfaSync struct {
+	pdf      *pdfstruct.PDF
+	xfa      *xfaForm
+	read     bool
+	bindings map[string]xfaBinding
+	changed  bool
+}
+
+// setField updates the XFA data, if any, to hold the current value of the
+// field.
+func (s *xfaSync) setField(field *fieldNode) (err error) {
+	if !s.read {
+		s.read = true
+		if s.xfa, err = getXFA(s.pdf); err != nil || s.xfa == nil || s.xfa.data == nil || s.xfa.template == nil {
+			s.xfa = nil
+			return err
+		}
+		s.bindings = make(map[string]xfaBinding)
+		for _, b := range s.xfa.bindings() {
+			if _, ok := s.bindings[b.field]; !ok {
+				s.bindings[b.field] = b
+			}
+		}
+	}
+	if s.xfa == nil {
+		return nil
+	}
+	b, ok := s.bindings[field.name]
+	if !ok {
+		return nil
+	}
+	var value = valueString(field)
+	if field.fieldType() == "Btn" && (value == "" || value == "Off") {
+		// A checkbox that is off has the second item as its value; a
+		// radio group with nothing selected is empty.
+		value = ""
+		if len(b.items) > 1 {
+			value = b.items[1]
+		}
+	} else if field.fieldType() == "Btn" && len(b.items) != 0 && !slices.Contains(b.items, value) {
+		// A checkbox that is on has the first item as its value,
+		// whatever its appearance state is called.
+		value = b.items[0]
+	}
+	var node = s.xfa.dataNode(b.path, true)
+	if node.text() == value && len(node.elements()) == 0 {
+		return nil
+	}
+	node.setText(value)
+	s.changed = true
+	return nil
+}
+
+// save writes the XFA data back to the PDF, if it has changed.
+func (s *xfaSync) save() {
+	if s.changed {
+		s.xfa.save(s.pdf)
+		s.changed = false
+	}
+}
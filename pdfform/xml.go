@@ -0,0 +1,207 @@
+package pdfform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// An xmlNode is a node in a parsed XML document.  It is a minimal document
+// model, used for reading and updating XFA packets, that preserves the
+// structure of the source (including namespace prefixes and whitespace) so
+// that it can be written back out with the same meaning.  The text is not
+// byte-for-byte the same:  CDATA sections are written as escaped character
+// data, and entity and character references are written as the characters
+// they stand for, except where they must be escaped.
+type xmlNode struct {
+	name  string     // qualified name as in the source (e.g. "xfa:data"); "" for non-elements
+	attrs []xml.Attr // attributes, with names as in the source
+	kids  []*xmlNode
+	raw   any // for non-elements: xml.CharData, xml.Comment, xml.ProcInst, or xml.Directive
+}
+
+// parseXML parses an XML document or fragment.  It returns a node without a
+// name, whose kids are the top-level nodes of the document.
+func parseXML(data []byte) (doc *xmlNode, err error) {
+	var (
+		dec   = xml.NewDecoder(bytes.NewReader(data))
+		stack = []*xmlNode{{}}
+	)
+	dec.Strict = false
+	for {
+		var tok xml.Token
+		if tok, err = dec.RawToken(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var top = stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			var node = &xmlNode{name: qualifiedName(tok.Name), attrs: tok.Copy().Attr}
+			top.kids = append(top.kids, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 1 || qualifiedName(tok.Name) != top.name {
+				return nil, errors.New("mismatched XML end element")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			top.kids = append(top.kids, &xmlNode{raw: xml.CopyToken(tok)})
+		}
+	}
+	if len(stack) != 1 {
+		return nil, errors.New("unterminated XML element")
+	}
+	return stack[0], nil
+}
+
+// qualifiedName returns the name as it appears in the source.
+func qualifiedName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// local returns the node's name without any namespace prefix.
+func (n *xmlNode) local() string {
+	if _, after, found := strings.Cut(n.name, ":"); found {
+		return after
+	}
+	return n.name
+}
+
+// attr returns the value of the named attribute, or "" if it isn't present.
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if qualifiedName(a.Name) == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// elements returns the element children of the node.
+func (n *xmlNode) elements() (elems []*xmlNode) {
+	for _, k := range n.kids {
+		if k.name != "" {
+			elems = append(elems, k)
+		}
+	}
+	return elems
+}
+
+// child returns the index'th element child of the node with the specified
+// local name, or nil if there isn't one.
+func (n *xmlNode) child(local string, index int) *xmlNode {
+	for _, k := range n.kids {
+		if k.name != "" && k.local() == local {
+			if index == 0 {
+				return k
+			}
+			index--
+		}
+	}
+	return nil
+}
+
+// text returns the character data directly inside the node.
+func (n *xmlNode) text() string {
+	var sb strings.Builder
+	for _, k := range n.kids {
+		if cd, ok := k.raw.(xml.CharData); ok {
+			sb.Write(cd)
+		}
+	}
+	return sb.String()
+}
+
+// setText replaces the contents of the node with the specified text.
+func (n *xmlNode) setText(s string) {
+	n.kids = []*xmlNode{{raw: xml.CharData(s)}}
+}
+
+// bytes returns the XML serialization of the node's kids (for a document
+// node) or of the node itself.
+func (n *xmlNode) bytes() []byte {
+	var buf bytes.Buffer
+	if n.name == "" && n.raw == nil {
+		for _, k := range n.kids {
+			k.write(&buf)
+		}
+	} else {
+		n.write(&buf)
+	}
+	return buf.Bytes()
+}
+
+// write writes the XML serialization of the node.
+func (n *xmlNode) write(buf *bytes.Buffer) {
+	switch raw := n.raw.(type) {
+	case xml.CharData:
+		escapeText(buf, raw)
+		return
+	case xml.Comment:
+		buf.WriteString("<!--")
+		buf.Write(raw)
+		buf.WriteString("-->")
+		return
+	case xml.ProcInst:
+		buf.WriteString("<?")
+		buf.WriteString(raw.Target)
+		if len(raw.Inst) != 0 {
+			buf.WriteByte(' ')
+			buf.Write(raw.Inst)
+		}
+		buf.WriteString("?>")
+		return
+	case xml.Directive:
+		buf.WriteString("<!")
+		buf.Write(raw)
+		buf.WriteString(">")
+		return
+	}
+	buf.WriteByte('<')
+	buf.WriteString(n.name)
+	for _, a := range n.attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(qualifiedName(a.Name))
+		buf.WriteString(`="`)
+		xml.EscapeText(buf, []byte(a.Value))
+		buf.WriteByte('"')
+	}
+	if len(n.kids) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteByte('>')
+	for _, k := range n.kids {
+		k.write(buf)
+	}
+	buf.WriteString("</")
+	buf.WriteString(n.name)
+	buf.WriteByte('>')
+}
+
+// escapeText writes character data, escaping the characters that can't appear
+// in it literally.  Unlike xml.EscapeText, it leaves newlines and tabs alone,
+// so that the formatting of the source is preserved.
+func escapeText(buf *bytes.Buffer, data []byte) {
+	for _, c := range data {
+		switch c {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '\r':
+			buf.WriteString("&#xD;") // a literal CR would be read as a newline
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package pdfstruct
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// An FDF is a Forms Data Format file.  It uses the same object syntax as a PDF,
+// but it is usually small and often lacks a cross-reference table, so it is
+// read into memory in its entirety.
+type FDF struct {
+	objects map[Reference]Object
+	Trailer Dict
+	Catalog Dict
+}
+
+// ReadFDF reads an FDF file.
+func ReadFDF(r io.Reader) (f *FDF, err error) {
+	var (
+		by  []byte
+		pos int
+	)
+	if by, err = io.ReadAll(r); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(by, []byte("%FDF-")) {
+		return nil, errors.New("not an FDF file")
+	}
+	f = &FDF{objects: make(map[Reference]Object)}
+	// Read all of the objects in the file, in order.  We don't bother
+	// with the cross-reference table, if any.
+	for {
+		for pos < len(by) && !isRegularChar(by[pos]) && by[pos] != '%' {
+			pos++
+		}
+		if pos < len(by) && by[pos] == '%' {
+			for pos < len(by) && by[pos] != '\r' && by[pos] != '\n' {
+				pos++
+			}
+			continue
+		}
+		if pos >= len(by) || bytes.HasPrefix(by[pos:], []byte("startxref")) {
+			break
+		}
+		if bytes.HasPrefix(by[pos:], []byte("xref")) {
+			if idx := bytes.Index(by[pos:], []byte("trailer")); idx >= 0 {
+				pos += idx
+				continue
+			}
+			return nil, fmt.Errorf("no trailer after xref at offset %d", pos)
+		}
+		if bytes.HasPrefix(by[pos:], []byte("trailer")) {
+			var obj Object
+			if _, pos, obj, err = readObject(pos+7, by[pos+7:], nil); err != nil {
+				return nil, fmt.Errorf("reading trailer at offset %d: %s", pos, err)
+			}
+			if trailer, ok := obj.(Dict); ok && f.Trailer == nil {
+				f.Trailer = trailer
+			}
+			continue
+		}
+		var match [][]byte
+		if match = refObjRE.FindSubmatch(by[pos:]); match == nil || match[3][0] != 'o' {
+			return nil, fmt.Errorf("expected object at offset %d", pos)
+		}
+		var ref Reference
+		var obj Object
+		ref.Number, _ = strconv.Atoi(string(match[1]))
+		ref.Generation, _ = strconv.Atoi(string(match[2]))
+		if _, pos, obj, err = readObject(pos, by[pos:], nil); err != nil {
+			return nil, fmt.Errorf("reading object %d at offset %d: %s", ref.Number, pos, err)
+		}
+		f.objects[ref] = obj
+	}
+	if f.Trailer == nil {
+		return nil, errors.New("FDF file has no trailer")
+	}
+	switch root := f.Trailer["Root"].(type) {
+	case Reference:
+		var obj Object
+		if obj, err = f.Get(root); err != nil {
+			return nil, fmt.Errorf("reading FDF catalog: %s", err)
+		}
+		if catalog, ok := obj.(Dict); ok {
+			f.Catalog = catalog
+		} else {
+			return nil, fmt.Errorf("FDF catalog is %T, not Dict", obj)
+		}
+	case Dict:
+		f.Catalog = root
+	default:
+		return nil, fmt.Errorf("FDF Root is %T, not Reference", root)
+	}
+	return f, nil
+}
+
+// Get returns the object specified by the reference.
+func (f *FDF) Get(r Reference) (obj Object, err error) {
+	var ok bool
+	if obj, ok = f.objects[r]; !ok {
+		return nil, fmt.Errorf("object number %d does not exist in FDF", r.Number)
+	}
+	return obj, nil
+}
+
+// WriteFDF writes an FDF file with the specified catalog, which should contain
+// the FDF dictionary.  The catalog is written as a single object, so it must not
+// contain any References.
+func WriteFDF(wr io.Writer, catalog Dict) (err error) {
+	if _, err = io.WriteString(wr, "%FDF-1.2\r\n%\xE2\xE3\xCF\xD3\r\n"); err != nil {
+		return err
+	}
+	if err = writeObject(wr, Reference{Number: 1}, catalog); err != nil {
+		return err
+	}
+	_, err = io.WriteString(wr, "trailer\r\n<< /Root 1 0 R >>\r\n%%EOF\r\n")
+	return err
+}
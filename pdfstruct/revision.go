@@ -0,0 +1,56 @@
+package pdfstruct
+
+import (
+	"io"
+)
+
+// ReadAt reads raw bytes from the PDF file, starting at the specified offset.
+// It does not see any changes that have not yet been written.
+func (p *PDF) ReadAt(buf []byte, offset int64) (n int, err error) {
+	return p.fh.ReadAt(buf, offset)
+}
+
+// Size returns the size of the PDF file.  It does not include any changes that
+// have not yet been written.
+func (p *PDF) Size() (size int64, err error) {
+	return p.fh.Seek(0, io.SeekEnd)
+}
+
+// Revision returns a read-only view of the PDF as it was before later
+// incremental updates were appended to it, i.e., as if the file ended at the
+// specified offset.  The offset must be the end of a revision (just after its
+// %%EOF marker).
+func (p *PDF) Revision(end int64) (rev *PDF, err error) {
+	return Open(io.NewSectionReader(p.fh, 0, end))
+}
+
+// ObjectOffset returns the offset in the file at which the specified object
+// starts.  It returns false if the object is not stored directly in the file,
+// e.g., if it is in an object stream or has not yet been written.
+func (p *PDF) ObjectOffset(ref Reference) (offset int64, ok bool) {
+	if ref.Number < 1 || ref.Number >= len(p.xref) {
+		return 0, false
+	}
+	if xe, ok := p.xref[ref.Number].(xrefDirect); ok && xe.gen == ref.Generation {
+		return int64(xe.offset), true
+	}
+	return 0, false
+}
+
+// Objects returns references to all of the objects in the PDF that are not on
+// the free list, including objects created but not yet written.
+func (p *PDF) Objects() (refs []Reference) {
+	for i, xe := range p.xref {
+		switch xe := xe.(type) {
+		case nil, xrefFree:
+			break
+		case xrefDirect:
+			refs = append(refs, Reference{Number: i, Generation: xe.gen})
+		default:
+			if i != 0 {
+				refs = append(refs, Reference{Number: i})
+			}
+		}
+	}
+	return refs
+}
@@ -36,6 +36,8 @@ func (p *PDF) CreateObject(obj Object) (ref Reference) {
 // Write updates the PDF in place to save the updated objects previously passed
 // to UpdateObject.  For this to work, the file handle passed to Open must
 // support io.WriteSeeker.  The caller needs to close the file when finished.
+// After Write, the PDF reflects the new revision of the file, so further
+// changes can be made and written as another incremental update.
 func (p *PDF) Write() (err error) {
 	var (
 		wr      io.WriteSeeker
@@ -83,9 +85,28 @@ func (p *PDF) Write() (err error) {
 	if err = writeStartXRef(wr, int(xref)); err != nil {
 		return err
 	}
+	// The written objects are now in the file.
+	p.xref = append(p.xref, nil)
+	for i, ref := range updates {
+		p.xref[ref.Number] = xrefDirect{offset: offsets[i], gen: ref.Generation}
+	}
+	p.start = int(xref)
+	p.updates = nil
 	return nil
 }
 
+// WriteAt overwrites bytes of the PDF file at the specified offset.  It is
+// intended for filling in placeholders, such as signature values, that can
+// only be computed after Write has been called; it is the caller's
+// responsibility not to damage the file structure.  The file handle passed to
+// Open must support io.WriterAt.
+func (p *PDF) WriteAt(buf []byte, offset int64) (n int, err error) {
+	if w, ok := p.fh.(io.WriterAt); ok {
+		return w.WriteAt(buf, offset)
+	}
+	return 0, errors.New("file handle not writable")
+}
+
 func writeObject(wr io.Writer, ref Reference, obj Object) (err error) {
 	if _, err = fmt.Fprintf(wr, "%d %d obj ", ref.Number, ref.Generation); err != nil {
 		return err
@@ -0,0 +1,202 @@
+package pdftext
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The AFM files for the standard 14 fonts are embedded, and registered at
+// startup.  See afm/MustRead.html for their terms of use.
+//
+//go:embed afm/*.afm
+var builtinAFM embed.FS
+
+func init() {
+	entries, _ := builtinAFM.ReadDir("afm")
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".afm") {
+			continue
+		}
+		fh, err := builtinAFM.Open("afm/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		if _, err = RegisterAFM(fh); err != nil {
+			panic(fmt.Sprintf("%s: %s", entry.Name(), err))
+		}
+		fh.Close()
+	}
+}
+
+// RegisterAFM reads font metrics from an Adobe Font Metrics (.afm) file and
+// registers them, so that the font can be used with Measure, FontMetrics, and
+// Draw.  It returns the name of the font (its FontName), which is the name
+// under which it is registered; any previously registered metrics with that
+// name are replaced.  The font is assumed to be used with WinAnsiEncoding, as
+// gofpdf does, unless its EncodingScheme is FontSpecific, in which case its own
+// character codes are used.  Fonts other than the standard 14 must also be
+// added to the gofpdf document, with the same family name, before calling
+// Draw.  RegisterAFM must not be called concurrently with other functions in
+// this package.
+func RegisterAFM(r io.Reader) (name string, err error) {
+	var fm *fontMetrics
+
+	if name, fm, err = parseAFM(r); err != nil {
+		return "", err
+	}
+	fm.computeHeights()
+	metrics[name] = fm
+	return name, nil
+}
+
+// afmChar is the metrics of one character from an AFM file.
+type afmChar struct {
+	code int
+	cm   charMetrics
+	ligs [][2]string // successor and ligature glyph names
+}
+
+// parseAFM parses an AFM file.
+func parseAFM(r io.Reader) (name string, fm *fontMetrics, err error) {
+	var (
+		scan    = bufio.NewScanner(r)
+		section string
+		lineno  int
+		chars   = make(map[string]*afmChar)
+		kerns   [][3]string
+		special bool // font has its own encoding
+	)
+	for scan.Scan() {
+		lineno++
+		var line = strings.TrimSpace(scan.Text())
+		var key, rest, _ = strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		switch {
+		case lineno == 1 && key != "StartFontMetrics":
+			return "", nil, errors.New("not an AFM file")
+		case key == "FontName" && section == "":
+			name = rest
+		case key == "EncodingScheme" && section == "":
+			special = rest == "FontSpecific"
+		case key == "StartCharMetrics" || key == "StartKernPairs" || key == "StartKernPairs0":
+			section = key
+		case key == "EndCharMetrics" || key == "EndKernPairs":
+			section = ""
+		case section == "StartCharMetrics" && line != "":
+			var cname string
+			var ac = afmChar{code: -1}
+			for _, item := range strings.Split(line, ";") {
+				var fields = strings.Fields(item)
+				if len(fields) == 0 {
+					continue
+				}
+				switch {
+				case fields[0] == "C" && len(fields) == 2:
+					ac.code, err = strconv.Atoi(fields[1])
+				case fields[0] == "CH" && len(fields) == 2:
+					var code int64
+					code, err = strconv.ParseInt(strings.Trim(fields[1], "<>"), 16, 0)
+					ac.code = int(code)
+				case (fields[0] == "WX" || fields[0] == "W0X") && len(fields) == 2:
+					ac.cm[0], err = parseAFMNumber(fields[1])
+				case fields[0] == "N" && len(fields) == 2:
+					cname = fields[1]
+				case fields[0] == "B" && len(fields) == 5:
+					if ac.cm[1], err = parseAFMNumber(fields[2]); err == nil {
+						ac.cm[2], err = parseAFMNumber(fields[4])
+					}
+				case fields[0] == "L" && len(fields) == 3:
+					ac.ligs = append(ac.ligs, [2]string{fields[1], fields[2]})
+				}
+				if err != nil {
+					return "", nil, fmt.Errorf("line %d: %s", lineno, err)
+				}
+			}
+			if cname == "" {
+				cname = strconv.Itoa(ac.code)
+			}
+			chars[cname] = &ac
+		case (section == "StartKernPairs" || section == "StartKernPairs0") && (key == "KPX" || key == "KP"):
+			var fields = strings.Fields(rest)
+			if len(fields) < 3 {
+				return "", nil, fmt.Errorf("line %d: invalid kerning pair", lineno)
+			}
+			kerns = append(kerns, [3]string{fields[0], fields[1], fields[2]})
+		}
+	}
+	if err = scan.Err(); err != nil {
+		return "", nil, err
+	}
+	if name == "" {
+		return "", nil, errors.New("AFM file has no FontName")
+	}
+	// Build the metrics for the characters in the encoding, including the
+	// ligatures and kerning pairs between them.
+	fm = &fontMetrics{chars: make([]charMetrics, 224), fontSpecific: special}
+	var codes = make(map[string][]byte)
+	if special {
+		for cname, ac := range chars {
+			if ac.code >= 32 && ac.code <= 255 {
+				codes[cname] = append(codes[cname], byte(ac.code))
+			}
+		}
+	} else {
+		for i, cname := range winAnsiNames {
+			if cname != "" && chars[cname] != nil {
+				codes[cname] = append(codes[cname], byte(i+32))
+			}
+		}
+	}
+	for cname, list := range codes {
+		var ac = chars[cname]
+		for _, code := range list {
+			fm.chars[code-32] = ac.cm
+			for _, lig := range ac.ligs {
+				if chars[lig[1]] == nil {
+					continue
+				}
+				for _, succ := range codes[lig[0]] {
+					if fm.ligatures == nil {
+						fm.ligatures = make(map[[2]byte]charMetrics)
+					}
+					fm.ligatures[[2]byte{code, succ}] = chars[lig[1]].cm
+				}
+			}
+		}
+	}
+	for _, kp := range kerns {
+		var amount int16
+		if len(codes[kp[0]]) == 0 || len(codes[kp[1]]) == 0 {
+			continue
+		}
+		if amount, err = parseAFMNumber(kp[2]); err != nil {
+			return "", nil, fmt.Errorf("kerning pair %s %s: %s", kp[0], kp[1], err)
+		}
+		if fm.kernpairs == nil {
+			fm.kernpairs = make(map[[2]byte]int16)
+		}
+		for _, c1 := range codes[kp[0]] {
+			for _, c2 := range codes[kp[1]] {
+				fm.kernpairs[[2]byte{c1, c2}] = amount
+			}
+		}
+	}
+	return name, fm, nil
+}
+
+// parseAFMNumber parses a number in an AFM file, rounding it to an integer.
+func parseAFMNumber(s string) (int16, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 {
+		return int16(f - 0.5), nil
+	}
+	return int16(f + 0.5), nil
+}
@@ -0,0 +1,120 @@
+package pdftext
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DrawContent draws a paragraph made up of spans into the specified box, as
+// DrawSpans does, but rather than drawing on a gofpdf page, it appends PDF
+// content stream operators to buf.  This allows the same layout to be used in
+// appearance streams and other content streams generated without gofpdf.  The
+// box is given in PDF coordinates:  x, y is its lower left corner.  fonts maps
+// the names of the fonts used by the spans to the names of the corresponding
+// font resources in the content stream; fonts not in the map are referred to
+// by their own names.  Text in the standard fonts and fonts loaded from AFM
+// files is written in WinAnsiEncoding (or the font's own encoding); text in
+// TrueType fonts is written as two-byte glyph IDs, for use with a Type0 font
+// with Identity-H encoding.  It returns whether the text fit in its box.
+func DrawContent(buf *bytes.Buffer, spans []Span, x, y, w, h float64, style Style, fonts map[string]string) (fits bool) {
+	var lines []line
+
+	if blank(spans) {
+		return true
+	}
+	lines, style, fits = layoutSpans(spans, w, h, style)
+	drawLines(&contentDrawer{buf: buf, fonts: fonts, flip: y + h}, lines, x, 0, w, h, style)
+	return fits
+}
+
+// contentDrawer draws text into a PDF content stream.  The y coordinates it is
+// given are distances down from flip, which is in PDF coordinates.
+type contentDrawer struct {
+	buf   *bytes.Buffer
+	fonts map[string]string
+	flip  float64
+	font  string
+}
+
+func (d *contentDrawer) setStyle(style Style) {
+	var resource = style.Font
+	if name, ok := d.fonts[style.Font]; ok {
+		resource = name
+	}
+	fmt.Fprintf(d.buf, "/%s %s Tf ", resource, contentNumber(style.FontSize))
+	if style.Color != nil {
+		fmt.Fprintf(d.buf, "%s %s %s rg\n", contentNumber(float64(style.Color[0])/255),
+			contentNumber(float64(style.Color[1])/255), contentNumber(float64(style.Color[2])/255))
+	} else {
+		d.buf.WriteString("0 g\n")
+	}
+	d.font = style.Font
+}
+
+func (d *contentDrawer) text(x, y float64, s string) {
+	fmt.Fprintf(d.buf, "BT %s %s Td ", contentNumber(x), contentNumber(d.flip-y))
+	if fm := metrics[d.font]; fm.glyphMetrics != nil {
+		d.buf.WriteByte('<')
+		for _, r := range s {
+			var g = fm.glyphs[r]
+			if int(g) >= len(fm.glyphMetrics) {
+				g = 0
+			}
+			fmt.Fprintf(d.buf, "%04X", g)
+		}
+		d.buf.WriteByte('>')
+	} else {
+		d.buf.WriteString(contentString(fm.encode(s)))
+	}
+	d.buf.WriteString(" Tj ET\n")
+}
+
+func (d *contentDrawer) clip(x, y, w, h float64) {
+	fmt.Fprintf(d.buf, "q %s %s %s %s re W n\n", contentNumber(x), contentNumber(d.flip-y-h), contentNumber(w), contentNumber(h))
+}
+
+func (d *contentDrawer) unclip() { d.buf.WriteString("Q\n") }
+
+func (d *contentDrawer) rotate(angle, x, y float64) {
+	var (
+		sin, cos = math.Sincos(angle * math.Pi / 180)
+		py       = d.flip - y
+	)
+	fmt.Fprintf(d.buf, "q %s %s %s %s %s %s cm\n", contentNumber(cos), contentNumber(sin), contentNumber(-sin), contentNumber(cos),
+		contentNumber(x-cos*x+sin*py), contentNumber(py-sin*x-cos*py))
+}
+
+func (d *contentDrawer) unrotate() { d.buf.WriteString("Q\n") }
+
+// contentNumber formats a number for a content stream, with at most five
+// decimal places.
+func contentNumber(f float64) string {
+	var s = strconv.FormatFloat(f, 'f', 5, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// contentString encodes a string in PDF literal string syntax.
+func contentString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\\', '(', ')':
+			sb.WriteByte('\\')
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+package pdftext
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Hyphenator finds the places where words can be hyphenated, using Liang's
+// algorithm (as in TeX) with a set of hyphenation patterns for a language.
+type Hyphenator struct {
+	// LeftMin and RightMin are the minimum numbers of letters before and
+	// after a hyphen.  They default to 2 and 3.
+	LeftMin, RightMin int
+
+	patterns   map[string][]uint8
+	exceptions map[string][]int
+	maxLen     int
+}
+
+// NewHyphenator reads hyphenation patterns in TeX format, such as the
+// hyph-*.tex or hyph-*.pat.txt files distributed with TeX, and returns a
+// Hyphenator using them.  Comments start with "%".  Patterns are letters with
+// interspersed digits (e.g. ".ach4"); exceptions are words with hyphens at the
+// allowed hyphenation points (e.g. "as-so-ciate"), and appear either inside
+// \hyphenation{...} or on their own.  Other TeX commands are ignored.
+func NewHyphenator(r io.Reader) (h *Hyphenator, err error) {
+	var (
+		scan       = bufio.NewScanner(r)
+		exceptions bool
+	)
+	h = &Hyphenator{LeftMin: 2, RightMin: 3, patterns: make(map[string][]uint8), exceptions: make(map[string][]int)}
+	for scan.Scan() {
+		var text, _, _ = strings.Cut(scan.Text(), "%")
+		text = strings.NewReplacer("{", " { ", "}", " } ").Replace(text)
+		for _, token := range strings.Fields(text) {
+			switch {
+			case token == "\\hyphenation":
+				exceptions = true
+			case token == "}":
+				exceptions = false
+			case token == "{" || strings.HasPrefix(token, "\\"):
+				break
+			case exceptions || (strings.ContainsRune(token, '-') && strings.IndexAny(token, "0123456789") < 0):
+				h.addException(token)
+			default:
+				h.addPattern(token)
+			}
+		}
+	}
+	if err = scan.Err(); err != nil {
+		return nil, err
+	}
+	if len(h.patterns) == 0 {
+		return nil, errors.New("no hyphenation patterns found")
+	}
+	return h, nil
+}
+
+// addPattern adds a hyphenation pattern.
+func (h *Hyphenator) addPattern(pattern string) {
+	var (
+		letters strings.Builder
+		levels  = []uint8{0}
+		count   int
+	)
+	for _, r := range pattern {
+		if r >= '0' && r <= '9' {
+			levels[len(levels)-1] = uint8(r - '0')
+		} else {
+			letters.WriteRune(unicode.ToLower(r))
+			levels = append(levels, 0)
+			count++
+		}
+	}
+	h.patterns[letters.String()] = levels
+	h.maxLen = max(h.maxLen, count)
+}
+
+// addException adds a hyphenation exception.
+func (h *Hyphenator) addException(word string) {
+	var (
+		letters strings.Builder
+		points  []int
+	)
+	for _, r := range word {
+		if r == '-' {
+			points = append(points, letters.Len())
+		} else {
+			letters.WriteRune(unicode.ToLower(r))
+		}
+	}
+	h.exceptions[letters.String()] = points
+}
+
+// Hyphenate returns the byte offsets in word at which a hyphen may be inserted.
+// word should consist of letters only.
+func (h *Hyphenator) Hyphenate(word string) (offsets []int) {
+	var (
+		lower  = strings.ToLower(word)
+		runes  = []rune("." + lower + ".")
+		points = make([]uint8, len(runes)+1)
+		count  = len(runes) - 2
+	)
+	if count < h.LeftMin+h.RightMin {
+		return nil
+	}
+	if points, ok := h.exceptions[lower]; ok && len(lower) == len(word) {
+		return points
+	}
+	for i := range runes {
+		for j := i + 1; j <= len(runes) && j-i <= h.maxLen; j++ {
+			if levels, ok := h.patterns[string(runes[i:j])]; ok {
+				for k, level := range levels {
+					points[i+k] = max(points[i+k], level)
+				}
+			}
+		}
+	}
+	// points[i+1] is the level of the break before the i'th letter.
+	var offset int
+	for i, r := range []rune(word) {
+		if i >= h.LeftMin && count-i >= h.RightMin && points[i+1]%2 == 1 {
+			offsets = append(offsets, offset)
+		}
+		offset += utf8.RuneLen(r)
+	}
+	return offsets
+}
@@ -0,0 +1,310 @@
+package pdftext
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A run is a piece of text drawn in a single style.  Its style has a known
+// font and a font size.
+type run struct {
+	text  string
+	style Style
+}
+
+// A line is a sequence of runs drawn on a single line.  It always has at least
+// one run, possibly with empty text, so that an empty line still has a style
+// (and therefore a height).
+type line struct {
+	runs []run
+	// wrapped is true if the line was ended by word wrapping, rather than
+	// by a newline or the end of the text.
+	wrapped bool
+	// start is the byte offset of the start of the line in the text being
+	// laid out.
+	start int
+}
+
+// text returns the text of the line.
+func (l line) text() string {
+	var sb strings.Builder
+	for _, r := range l.runs {
+		sb.WriteString(r.text)
+	}
+	return sb.String()
+}
+
+// measure returns the width of the line and its height above and below the
+// baseline.
+func (l line) measure() (width, habove, hbelow float64) {
+	for _, r := range l.runs {
+		w, ha, hb := Measure(r.text, r.style.Font, r.style.FontSize)
+		width += w
+		habove, hbelow = max(habove, ha), max(hbelow, hb)
+	}
+	return width, habove, hbelow
+}
+
+// fontMetrics returns the largest font heights above and below the baseline of
+// the fonts in the line.
+func (l line) fontMetrics() (habove, hbelow float64) {
+	for _, r := range l.runs {
+		ha, hb := FontMetrics(r.style.Font, r.style.FontSize)
+		habove, hbelow = max(habove, ha), max(hbelow, hb)
+	}
+	return habove, hbelow
+}
+
+// fontSize returns the largest font size in the line.
+func (l line) fontSize() (size float64) {
+	for _, r := range l.runs {
+		size = max(size, r.style.FontSize)
+	}
+	return size
+}
+
+// lineHeight returns the distance from the previous baseline to the baseline
+// of the line: the largest line height of the runs in it.
+func (l line) lineHeight() (lh float64) {
+	for _, r := range l.runs {
+		var rlh = r.style.LineHeight * r.style.FontSize
+		if rlh == 0 {
+			rlh = r.style.FontSize
+		}
+		lh = max(lh, rlh)
+	}
+	return lh
+}
+
+// slice returns the part of the line between the specified byte offsets in its
+// text.
+func (l line) slice(start, end int) (sliced line) {
+	var offset int
+	for _, r := range l.runs {
+		var rstart, rend = offset, offset + len(r.text)
+		offset = rend
+		if from, to := max(start, rstart), min(end, rend); from < to {
+			sliced.runs = append(sliced.runs, run{r.text[from-rstart : to-rstart], r.style})
+		}
+	}
+	if len(sliced.runs) == 0 {
+		// Keep the style of the text at the start position.
+		offset = 0
+		for _, r := range l.runs {
+			if offset += len(r.text); offset > start {
+				return line{runs: []run{{"", r.style}}}
+			}
+		}
+		return line{runs: []run{{"", l.runs[len(l.runs)-1].style}}}
+	}
+	return sliced
+}
+
+// Zero-width spaces mark places where lines can be broken, and soft hyphens
+// mark places where words can be hyphenated.  Neither is drawn, except that a
+// soft hyphen at a line break is drawn as a hyphen.
+const (
+	zeroWidthSpace = '​'
+	softHyphen     = '­'
+)
+
+var invisibleRemover = strings.NewReplacer(string(zeroWidthSpace), "", string(softHyphen), "")
+
+// finish returns the line as it will be drawn: without zero-width spaces or
+// soft hyphens, and with a hyphen at the end if hyphen is true.
+func (l line) finish(hyphen bool) (finished line) {
+	finished.wrapped, finished.start = l.wrapped, l.start
+	for _, r := range l.runs {
+		if strings.ContainsRune(r.text, zeroWidthSpace) || strings.ContainsRune(r.text, softHyphen) {
+			r.text = invisibleRemover.Replace(r.text)
+		}
+		finished.runs = append(finished.runs, r)
+	}
+	if hyphen {
+		finished.runs[len(finished.runs)-1].text += "-"
+	}
+	return finished
+}
+
+// splitLines splits runs of text into lines at newlines.
+func splitLines(runs []run) (lines []line) {
+	var (
+		cur    line
+		offset int
+	)
+	for _, r := range runs {
+		for {
+			var before, after, found = strings.Cut(r.text, "\n")
+			if before != "" || len(cur.runs) == 0 {
+				cur.runs = append(cur.runs, run{before, r.style})
+			}
+			offset += len(before)
+			if !found {
+				break
+			}
+			lines = append(lines, cur)
+			offset++
+			cur, r.text = line{start: offset}, after
+		}
+	}
+	return append(lines, cur)
+}
+
+// A breakPoint is a place where a line can be broken.
+type breakPoint struct {
+	end    int  // byte offset at which the line ends
+	next   int  // byte offset at which the next line starts
+	hyphen bool // a hyphen is drawn at the end of the line
+}
+
+// breakPoints returns the places where a line of text can be broken, in order.
+// The line can be broken at a non-initial run of spaces; after a hyphen, dash,
+// or slash between two non-spaces; at a zero-width space; and at a soft
+// hyphen.  If the line's style has a Hyphenator, words that don't have soft
+// hyphens can also be hyphenated.
+func breakPoints(l line) (bps []breakPoint) {
+	var (
+		text      = l.text()
+		hyph      = l.runs[0].style.Hyphenator
+		prev      rune
+		wordStart = -1
+		marked    bool // word has soft hyphens
+	)
+	var endWord = func(end int) {
+		if hyph != nil && wordStart >= 0 && !marked {
+			for _, o := range hyph.Hyphenate(text[wordStart:end]) {
+				bps = append(bps, breakPoint{wordStart + o, wordStart + o, true})
+			}
+		}
+		wordStart, marked = -1, false
+	}
+	for i, r := range text {
+		var size = utf8.RuneLen(r)
+		switch {
+		case unicode.IsLetter(r):
+			if wordStart < 0 {
+				wordStart = i
+			}
+		case r == softHyphen:
+			if i > 0 {
+				bps = append(bps, breakPoint{i, i + size, true})
+			}
+			marked = wordStart >= 0
+		case r == ' ':
+			endWord(i)
+			if i > 0 && prev != ' ' {
+				var next = i
+				for next < len(text) && text[next] == ' ' {
+					next++
+				}
+				bps = append(bps, breakPoint{i, next, false})
+			}
+		case r == '-' || r == '‐' || r == '–' || r == '—' || r == '/':
+			endWord(i)
+			if next, _ := utf8.DecodeRuneInString(text[i+size:]); i > 0 && prev != ' ' && i+size < len(text) && next != ' ' {
+				bps = append(bps, breakPoint{i + size, i + size, false})
+			}
+		case r == zeroWidthSpace:
+			endWord(i)
+			if i > 0 {
+				bps = append(bps, breakPoint{i, i + size, false})
+			}
+		default:
+			endWord(i)
+		}
+		prev = r
+	}
+	endWord(len(text))
+	slices.SortStableFunc(bps, func(a, b breakPoint) int { return a.end - b.end })
+	return bps
+}
+
+// fitLines determines whether the text fits in the box, and how it got
+// word-wrapped in order to fit.  If breakWords is true, words that are too
+// long to fit on a line by themselves are broken between characters.
+func fitLines(runs []run, w, h float64, wrap, breakWords bool) (lines []line, fits bool) {
+	// Start by assuming it will fit, until we find out otherwise.
+	var height = h
+	fits = true
+	// Break the text up into lines and handle each one separately.
+	lines = splitLines(runs)
+	for i := 0; i < len(lines); i++ {
+		var (
+			full = lines[i]
+			text = full.text()
+			bp   = breakPoint{len(text), len(text), false}
+		)
+		// Measure the line to see if it fits.
+		if width, _, _ := full.finish(false).measure(); width > w {
+			// It doesn't fit.  Find the last place where we can
+			// break it such that it does.
+			var (
+				bps   []breakPoint
+				found bool
+			)
+			if wrap {
+				bps = breakPoints(full)
+			}
+			for j := len(bps) - 1; j >= 0 && !found; j-- {
+				if width, _, _ := full.slice(0, bps[j].end).finish(bps[j].hyphen).measure(); width <= w {
+					bp, found = bps[j], true
+				}
+			}
+			if !found && wrap && breakWords {
+				// The first word is too long by itself.  Break
+				// it at the last character boundary that fits.
+				var end = len(text)
+				if len(bps) != 0 {
+					end = bps[0].end
+				}
+				for !found && end > 0 {
+					_, size := utf8.DecodeLastRuneInString(text[:end])
+					if end -= size; end == 0 {
+						break
+					}
+					if width, _, _ := full.slice(0, end).finish(false).measure(); width <= w {
+						bp, found = breakPoint{end, end, false}, true
+					}
+				}
+			}
+			if !found {
+				// Can't word wrap (any further).  The whole
+				// value will not fit.  We'll accept overflowing
+				// this line, breaking it at the first
+				// opportunity, but we'll still continue laying
+				// out the rest of the lines to do the best we
+				// can.
+				if len(bps) != 0 {
+					bp = bps[0]
+				}
+				fits = false
+			}
+		}
+		// If we had to take a tail off the line to word wrap, put that
+		// into the slice as the next line, and remove it from the
+		// current line.
+		var next = bp.next
+		for next < len(text) && text[next] == ' ' {
+			next++
+		}
+		if next < len(text) {
+			lines = slices.Insert(lines, i+1, line{runs: full.slice(next, len(text)).runs, wrapped: full.wrapped, start: full.start + next})
+			full = line{runs: full.slice(0, bp.end).runs, wrapped: true, start: full.start}
+		}
+		lines[i] = full.finish(bp.hyphen && full.wrapped)
+		// Remove the line's vertical size from bbox.
+		height -= lines[i].lineHeight()
+	}
+	// For the last line, use the minimum of the font height and the line
+	// height.
+	var last = lines[len(lines)-1]
+	height = height + last.lineHeight() - min(last.lineHeight(), last.fontSize())
+	// Did the value fit vertically?
+	if height < 0 {
+		fits = false
+	}
+	// Return the result.
+	return lines, fits
+}
@@ -3,9 +3,11 @@ package pdftext
 // Measure returns the metrics of the specified string in the specified font at
 // the specified size: specifically, the width, the height above the baseline,
 // and the height below the baseline.  The string must not contain newlines.
-// Characters that are not recognized (i.e., any non-ASCII characters) are given
-// the size of an 'x', so the result could be imprecise if such characters are
-// included.  The function returns zeros if the font is not known.
+// For the standard fonts and fonts loaded from AFM files, the string is
+// converted to WinAnsiEncoding (cp1252), as Draw does when drawing it, and
+// characters that can't be represented are measured as '?'.  For TrueType
+// fonts, characters not in the font are given the size of its "missing
+// character" glyph.  The function returns zeros if the font is not known.
 func Measure(s, font string, size float64) (width, habove, hbelow float64) {
 	w, ha, hb := measure(s, font)
 	return float64(w) * size / 1000.0, float64(ha) * size / 1000.0, float64(hb) * size / 1000.0
@@ -15,6 +17,10 @@ func measure(s, font string) (width, habove, hbelow int) {
 	if fm == nil {
 		return 0, 0, 0
 	}
+	if fm.glyphMetrics != nil {
+		return measureGlyphs(s, fm)
+	}
+	s = fm.encode(s)
 	for s != "" {
 		var cm [3]int16
 		if len(s) > 1 {
@@ -28,11 +34,11 @@ func measure(s, font string) (width, habove, hbelow int) {
 				width += int(fm.kernpairs[key])
 			}
 		}
-		if cm[0] == 0 && s[0] >= 32 && s[0] <= 126 {
+		if cm[0] == 0 && s[0] >= 32 && int(s[0]-32) < len(fm.chars) {
 			cm = fm.chars[s[0]-32]
 			s = s[1:]
 		} else if cm[0] == 0 {
-			cm = fm.chars['x'-32]
+			cm = fm.chars['?'-32]
 			s = s[1:]
 		}
 		width += int(cm[0])
@@ -42,3 +48,24 @@ func measure(s, font string) (width, habove, hbelow int) {
 	hbelow = -hbelow
 	return
 }
+
+// measureGlyphs measures a string in a TrueType font.
+func measureGlyphs(s string, fm *fontMetrics) (width, habove, hbelow int) {
+	var prev uint16
+	for i, r := range s {
+		var g = fm.glyphs[r]
+		if int(g) >= len(fm.glyphMetrics) {
+			g = 0
+		}
+		if i != 0 && fm.kerning != nil {
+			width += int(fm.kerning.kern(prev, g))
+		}
+		var cm = fm.glyphMetrics[g]
+		width += int(cm[0])
+		hbelow = min(hbelow, int(cm[1]))
+		habove = max(habove, int(cm[2]))
+		prev = g
+	}
+	hbelow = -hbelow
+	return
+}
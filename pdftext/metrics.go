@@ -12,723 +12,35 @@ func FontMetrics(font string, fontSize float64) (habove, hbelow float64) {
 }
 
 type fontMetrics struct {
-	chars     []charMetrics // index is ASCII-32, max 94
+	chars     []charMetrics // index is character code-32, max 224
 	ligatures map[[2]byte]charMetrics
 	kernpairs map[[2]byte]int16
 	habove    int16
 	hbelow    int16
+	// fontSpecific is true for fonts (such as Symbol) that have their own
+	// encoding rather than WinAnsiEncoding.
+	fontSpecific bool
+	// TrueType and OpenType fonts have metrics by glyph rather than by
+	// character code, and a mapping from runes to glyphs.
+	glyphs       map[rune]uint16
+	glyphMetrics []charMetrics
+	kerning      ttKerning
 }
 type charMetrics [3]int16
 
-func init() {
-	for _, fm := range metrics {
-		for _, cm := range fm.chars {
-			fm.habove = max(fm.habove, cm[2])
-			fm.hbelow = max(fm.hbelow, -cm[1])
-		}
-		for _, cm := range fm.ligatures {
-			fm.habove = max(fm.habove, cm[2])
-			fm.hbelow = max(fm.hbelow, -cm[1])
-		}
-	}
-}
+// metrics holds the metrics of all known fonts, keyed by font name.  The
+// standard 14 fonts are registered from embedded AFM files at startup.
+var metrics = map[string]*fontMetrics{}
 
-var metrics = map[string]*fontMetrics{
-	"Courier": {
-		chars: []charMetrics{
-			{600, 0, 0},      // space
-			{600, -15, 572},  // !
-			{600, 328, 562},  // "
-			{600, -32, 639},  // #
-			{600, -126, 662}, // $
-			{600, -15, 622},  // %
-			{600, -15, 543},  // &
-			{600, 328, 562},  // '
-			{600, -108, 622}, // (
-			{600, -108, 622}, // )
-			{600, 257, 607},  // *
-			{600, 44, 470},   // +
-			{600, -112, 122}, // ,
-			{600, 231, 285},  // -
-			{600, -15, 109},  // .
-			{600, -80, 629},  // /
-			{600, -15, 622},  // 0
-			{600, 0, 622},    // 1
-			{600, 0, 622},    // 2
-			{600, -15, 622},  // 3
-			{600, 0, 622},    // 4
-			{600, -15, 607},  // 5
-			{600, -15, 622},  // 6
-			{600, 0, 607},    // 7
-			{600, -15, 622},  // 8
-			{600, -15, 622},  // 9
-			{600, -15, 385},  // :
-			{600, -112, 385}, // ;
-			{600, 42, 472},   // <
-			{600, 138, 376},  // =
-			{600, 42, 472},   // >
-			{600, -15, 572},  // ?
-			{600, -15, 622},  // @
-			{600, 0, 562},    // A
-			{600, 0, 562},    // B
-			{600, -18, 580},  // C
-			{600, 0, 562},    // D
-			{600, 0, 562},    // E
-			{600, 0, 562},    // F
-			{600, -18, 580},  // G
-			{600, 0, 562},    // H
-			{600, 0, 562},    // I
-			{600, -18, 562},  // J
-			{600, 0, 562},    // K
-			{600, 0, 562},    // L
-			{600, 0, 562},    // M
-			{600, -13, 562},  // N
-			{600, -18, 580},  // O
-			{600, 0, 562},    // P
-			{600, -138, 580}, // Q
-			{600, 0, 562},    // R
-			{600, -20, 580},  // S
-			{600, 0, 562},    // T
-			{600, -18, 562},  // U
-			{600, -13, 562},  // V
-			{600, -13, 562},  // W
-			{600, 0, 562},    // X
-			{600, 0, 562},    // Y
-			{600, 0, 562},    // Z
-			{600, -108, 622}, // [
-			{600, -80, 629},  // \
-			{600, -108, 622}, // ]
-			{600, 354, 622},  // ^
-			{600, -125, -75}, // _
-			{600, 328, 562},  // `
-			{600, -15, 441},  // a
-			{600, -15, 629},  // b
-			{600, -15, 441},  // c
-			{600, -15, 629},  // d
-			{600, -15, 441},  // e
-			{600, 0, 629},    // f
-			{600, -157, 441}, // g
-			{600, 0, 629},    // h
-			{600, 0, 657},    // i
-			{600, -157, 657}, // j
-			{600, 0, 629},    // k
-			{600, 0, 629},    // l
-			{600, 0, 441},    // m
-			{600, 0, 441},    // n
-			{600, -15, 441},  // o
-			{600, -157, 441}, // p
-			{600, -157, 441}, // q
-			{600, 0, 441},    // r
-			{600, -15, 441},  // s
-			{600, -15, 561},  // t
-			{600, -15, 426},  // u
-			{600, -10, 426},  // v
-			{600, -10, 426},  // w
-			{600, 0, 426},    // x
-			{600, -157, 426}, // y
-			{600, 0, 426},    // z
-			{600, -108, 622}, // {
-			{600, -250, 750}, // |
-			{600, -108, 622}, // }
-			{600, 197, 320},  // ~
-		},
-		ligatures: map[[2]byte]charMetrics{
-			{'f', 'i'}: {600, 0, 629},
-			{'f', 'l'}: {600, 0, 629},
-		},
-	},
-	"Helvetica": {
-		chars: []charMetrics{
-			{278, 0, 0},      // space
-			{278, 0, 718},    // !
-			{355, 463, 718},  // "
-			{556, 0, 688},    // #
-			{556, -115, 775}, // $
-			{889, -19, 703},  // %
-			{667, -15, 718},  // &
-			{222, 463, 718},  // '
-			{333, -207, 733}, // (
-			{333, -207, 733}, // )
-			{389, 431, 718},  // *
-			{584, 0, 505},    // +
-			{278, -147, 106}, // ,
-			{333, 232, 322},  // -
-			{278, 0, 106},    // .
-			{278, -19, 737},  // /
-			{556, -19, 703},  // 0
-			{556, 0, 703},    // 1
-			{556, 0, 703},    // 2
-			{556, -19, 703},  // 3
-			{556, 0, 703},    // 4
-			{556, -19, 688},  // 5
-			{556, -19, 703},  // 6
-			{556, 0, 688},    // 7
-			{556, -19, 703},  // 8
-			{556, -19, 703},  // 9
-			{278, 0, 516},    // :
-			{278, -147, 516}, // ;
-			{584, 11, 495},   // <
-			{584, 115, 390},  // =
-			{584, 11, 495},   // >
-			{556, 0, 727},    // ?
-			{1015, -19, 737}, // @
-			{667, 0, 718},    // A
-			{667, 0, 718},    // B
-			{722, -19, 737},  // C
-			{722, 0, 718},    // D
-			{667, 0, 718},    // E
-			{611, 0, 718},    // F
-			{778, -19, 737},  // G
-			{722, 0, 718},    // H
-			{278, 0, 718},    // I
-			{500, -19, 718},  // J
-			{667, 0, 718},    // K
-			{556, 0, 718},    // L
-			{833, 0, 718},    // M
-			{722, 0, 718},    // N
-			{778, -19, 737},  // O
-			{667, 0, 718},    // P
-			{778, -56, 737},  // Q
-			{722, 0, 718},    // R
-			{667, -19, 737},  // S
-			{611, 0, 718},    // T
-			{722, -19, 718},  // U
-			{667, 0, 718},    // V
-			{944, 0, 718},    // W
-			{667, 0, 718},    // X
-			{667, 0, 718},    // Y
-			{611, 0, 718},    // Z
-			{278, -196, 722}, // [
-			{278, -19, 737},  // \
-			{278, -196, 722}, // ]
-			{469, 264, 688},  // ^
-			{556, -125, -75}, // _
-			{222, 470, 725},  // `
-			{556, -15, 538},  // a
-			{556, -15, 718},  // b
-			{500, -15, 538},  // c
-			{556, -15, 718},  // d
-			{556, -15, 538},  // e
-			{278, 0, 728},    // f
-			{556, -220, 538}, // g
-			{556, 0, 718},    // h
-			{222, 0, 718},    // i
-			{222, -210, 718}, // j
-			{500, 0, 718},    // k
-			{222, 0, 718},    // l
-			{833, 0, 538},    // m
-			{556, 0, 538},    // n
-			{556, -14, 538},  // o
-			{556, -207, 538}, // p
-			{556, -207, 538}, // q
-			{333, 0, 538},    // r
-			{500, -15, 538},  // s
-			{278, -7, 669},   // t
-			{556, -15, 523},  // u
-			{500, 0, 523},    // v
-			{722, 0, 523},    // w
-			{500, 0, 523},    // x
-			{500, -214, 523}, // y
-			{500, 0, 523},    // z
-			{334, -196, 722}, // {
-			{260, -225, 775}, // |
-			{334, -196, 722}, // }
-			{584, 180, 326},  // ~
-		},
-		ligatures: map[[2]byte]charMetrics{
-			{'f', 'i'}: {500, 0, 728},
-			{'f', 'l'}: {500, 0, 728},
-		},
-		kernpairs: map[[2]byte]int16{
-			{'A', 'C'}:   -30,
-			{'A', 'G'}:   -30,
-			{'A', 'O'}:   -30,
-			{'A', 'Q'}:   -30,
-			{'A', 'T'}:   -120,
-			{'A', 'U'}:   -50,
-			{'A', 'V'}:   -70,
-			{'A', 'W'}:   -50,
-			{'A', 'Y'}:   -100,
-			{'A', 'u'}:   -30,
-			{'A', 'v'}:   -40,
-			{'A', 'w'}:   -40,
-			{'A', 'y'}:   -40,
-			{'B', 'U'}:   -10,
-			{'B', ','}:   -20,
-			{'B', '.'}:   -20,
-			{'C', ','}:   -30,
-			{'C', '.'}:   -30,
-			{'D', 'A'}:   -40,
-			{'D', 'V'}:   -70,
-			{'D', 'W'}:   -40,
-			{'D', 'Y'}:   -90,
-			{'D', ','}:   -70,
-			{'D', '.'}:   -70,
-			{'F', 'A'}:   -80,
-			{'F', 'a'}:   -50,
-			{'F', ','}:   -150,
-			{'F', 'e'}:   -30,
-			{'F', 'o'}:   -30,
-			{'F', '.'}:   -150,
-			{'F', 'r'}:   -45,
-			{'J', 'A'}:   -20,
-			{'J', 'a'}:   -20,
-			{'J', ','}:   -30,
-			{'J', '.'}:   -30,
-			{'J', 'u'}:   -20,
-			{'K', 'O'}:   -50,
-			{'K', 'e'}:   -40,
-			{'K', 'o'}:   -40,
-			{'K', 'u'}:   -30,
-			{'K', 'y'}:   -50,
-			{'L', 'T'}:   -110,
-			{'L', 'V'}:   -110,
-			{'L', 'W'}:   -70,
-			{'L', 'Y'}:   -140,
-			{'L', '\''}:  -160,
-			{'L', 'y'}:   -30,
-			{'O', 'A'}:   -20,
-			{'O', 'T'}:   -40,
-			{'O', 'V'}:   -50,
-			{'O', 'W'}:   -30,
-			{'O', 'X'}:   -60,
-			{'O', 'Y'}:   -70,
-			{'O', ','}:   -40,
-			{'O', '.'}:   -40,
-			{'P', 'A'}:   -120,
-			{'P', 'a'}:   -40,
-			{'P', ','}:   -180,
-			{'P', 'e'}:   -50,
-			{'P', 'o'}:   -50,
-			{'P', '.'}:   -180,
-			{'Q', 'U'}:   -10,
-			{'R', 'O'}:   -20,
-			{'R', 'T'}:   -30,
-			{'R', 'U'}:   -40,
-			{'R', 'V'}:   -50,
-			{'R', 'W'}:   -30,
-			{'R', 'Y'}:   -50,
-			{'S', ','}:   -20,
-			{'S', '.'}:   -20,
-			{'T', 'A'}:   -120,
-			{'T', 'O'}:   -40,
-			{'T', 'a'}:   -120,
-			{'T', ':'}:   -20,
-			{'T', ','}:   -120,
-			{'T', 'e'}:   -120,
-			{'T', '-'}:   -140,
-			{'T', 'o'}:   -120,
-			{'T', '.'}:   -120,
-			{'T', 'r'}:   -120,
-			{'T', ';'}:   -20,
-			{'T', 'u'}:   -120,
-			{'T', 'w'}:   -120,
-			{'T', 'y'}:   -120,
-			{'U', 'A'}:   -40,
-			{'U', ','}:   -40,
-			{'U', '.'}:   -40,
-			{'V', 'A'}:   -80,
-			{'V', 'G'}:   -40,
-			{'V', 'O'}:   -40,
-			{'V', 'a'}:   -70,
-			{'V', ':'}:   -40,
-			{'V', ','}:   -125,
-			{'V', 'e'}:   -80,
-			{'V', '-'}:   -80,
-			{'V', 'o'}:   -80,
-			{'V', '.'}:   -125,
-			{'V', ';'}:   -40,
-			{'V', 'u'}:   -70,
-			{'W', 'A'}:   -50,
-			{'W', 'O'}:   -20,
-			{'W', 'a'}:   -40,
-			{'W', ','}:   -80,
-			{'W', 'e'}:   -30,
-			{'W', '-'}:   -40,
-			{'W', 'o'}:   -30,
-			{'W', '.'}:   -80,
-			{'W', 'u'}:   -30,
-			{'W', 'y'}:   -20,
-			{'Y', 'A'}:   -110,
-			{'Y', 'O'}:   -85,
-			{'Y', 'a'}:   -140,
-			{'Y', ':'}:   -60,
-			{'Y', ','}:   -140,
-			{'Y', 'e'}:   -140,
-			{'Y', '-'}:   -140,
-			{'Y', 'i'}:   -20,
-			{'Y', 'o'}:   -140,
-			{'Y', '.'}:   -140,
-			{'Y', ';'}:   -60,
-			{'Y', 'u'}:   -110,
-			{'a', 'v'}:   -20,
-			{'a', 'w'}:   -20,
-			{'a', 'y'}:   -30,
-			{'b', 'b'}:   -10,
-			{'b', ','}:   -40,
-			{'b', 'l'}:   -20,
-			{'b', '.'}:   -40,
-			{'b', 'u'}:   -20,
-			{'b', 'v'}:   -20,
-			{'b', 'y'}:   -20,
-			{'c', ','}:   -15,
-			{'c', 'k'}:   -20,
-			{',', ':'}:   -50,
-			{',', '\''}:  -100,
-			{'e', ','}:   -15,
-			{'e', '.'}:   -15,
-			{'e', 'v'}:   -30,
-			{'e', 'w'}:   -20,
-			{'e', 'x'}:   -30,
-			{'e', 'y'}:   -20,
-			{'f', 'a'}:   -30,
-			{'f', ','}:   -30,
-			{'f', 'e'}:   -30,
-			{'f', 'o'}:   -30,
-			{'f', '.'}:   -30,
-			{'f', '\''}:  50,
-			{'g', 'r'}:   -10,
-			{'h', 'y'}:   -30,
-			{'k', 'e'}:   -20,
-			{'k', 'o'}:   -20,
-			{'m', 'u'}:   -10,
-			{'m', 'y'}:   -15,
-			{'n', 'u'}:   -10,
-			{'n', 'v'}:   -20,
-			{'n', 'y'}:   -15,
-			{'o', ','}:   -40,
-			{'o', '.'}:   -40,
-			{'o', 'v'}:   -15,
-			{'o', 'w'}:   -15,
-			{'o', 'x'}:   -30,
-			{'o', 'y'}:   -30,
-			{'p', ','}:   -35,
-			{'p', '.'}:   -35,
-			{'p', 'y'}:   -30,
-			{'.', '\''}:  -100,
-			{'.', ' '}:   -60,
-			{'`', '`'}:   -57,
-			{'\'', 'd'}:  -50,
-			{'\'', '\''}: -57,
-			{'\'', 'r'}:  -50,
-			{'\'', 's'}:  -50,
-			{'\'', ' '}:  -70,
-			{'r', 'a'}:   -10,
-			{'r', ':'}:   30,
-			{'r', ','}:   -50,
-			{'r', 'i'}:   15,
-			{'r', 'k'}:   15,
-			{'r', 'l'}:   15,
-			{'r', 'm'}:   25,
-			{'r', 'n'}:   25,
-			{'r', 'p'}:   30,
-			{'r', '.'}:   -50,
-			{'r', ';'}:   30,
-			{'r', 't'}:   40,
-			{'r', 'u'}:   15,
-			{'r', 'v'}:   30,
-			{'r', 'y'}:   30,
-			{'s', ','}:   -15,
-			{'s', '.'}:   -15,
-			{'s', 'w'}:   -30,
-			{';', ' '}:   -50,
-			{' ', 'T'}:   -50,
-			{' ', 'V'}:   -50,
-			{' ', 'W'}:   -40,
-			{' ', 'Y'}:   -90,
-			{' ', '`'}:   -60,
-			{'v', 'a'}:   -25,
-			{'v', ','}:   -80,
-			{'v', 'e'}:   -25,
-			{'v', 'o'}:   -25,
-			{'v', '.'}:   -80,
-			{'w', 'a'}:   -15,
-			{'w', ','}:   -60,
-			{'w', 'e'}:   -10,
-			{'w', 'o'}:   -10,
-			{'w', '.'}:   -60,
-			{'x', 'e'}:   -30,
-			{'y', 'a'}:   -20,
-			{'y', ','}:   -100,
-			{'y', 'e'}:   -20,
-			{'y', 'o'}:   -20,
-			{'y', '.'}:   -100,
-			{'z', 'e'}:   -15,
-			{'z', 'o'}:   -15,
-		},
-	},
-	"Times-Roman": {
-		chars: []charMetrics{
-			{250, 0, 0},      // space
-			{333, -9, 676},   // !
-			{408, 431, 676},  // "
-			{500, 0, 662},    // #
-			{500, -87, 727},  // $
-			{833, -13, 676},  // %
-			{778, -13, 676},  // &
-			{333, 433, 676},  // '
-			{333, -177, 676}, // (
-			{333, -177, 676}, // )
-			{500, 265, 676},  // *
-			{564, 0, 506},    // +
-			{250, -141, 102}, // ,
-			{333, 194, 257},  // -
-			{250, -11, 100},  // .
-			{278, -14, 676},  // /
-			{500, -14, 676},  // 0
-			{500, 0, 676},    // 1
-			{500, 0, 676},    // 2
-			{500, -14, 676},  // 3
-			{500, 0, 676},    // 4
-			{500, -14, 688},  // 5
-			{500, -14, 684},  // 6
-			{500, -8, 662},   // 7
-			{500, -14, 676},  // 8
-			{500, -22, 676},  // 9
-			{278, -11, 459},  // :
-			{278, -141, 459}, // ;
-			{564, -8, 514},   // <
-			{564, 120, 386},  // =
-			{564, -8, 514},   // >
-			{444, -8, 676},   // ?
-			{921, -14, 676},  // @
-			{722, 0, 674},    // A
-			{667, 0, 662},    // B
-			{667, -14, 676},  // C
-			{722, 0, 662},    // D
-			{611, 0, 662},    // E
-			{556, 0, 662},    // F
-			{722, -14, 676},  // G
-			{722, 0, 662},    // H
-			{333, 0, 662},    // I
-			{389, -14, 662},  // J
-			{722, 0, 662},    // K
-			{611, 0, 662},    // L
-			{889, 0, 662},    // M
-			{722, -11, 662},  // N
-			{722, -14, 676},  // O
-			{556, 0, 662},    // P
-			{722, -178, 676}, // Q
-			{667, 0, 662},    // R
-			{556, -14, 676},  // S
-			{611, 0, 662},    // T
-			{722, -14, 662},  // U
-			{722, -11, 662},  // V
-			{944, -11, 662},  // W
-			{722, 0, 662},    // X
-			{722, 0, 662},    // Y
-			{611, 0, 662},    // Z
-			{333, -156, 662}, // [
-			{278, -14, 676},  // \
-			{333, -156, 662}, // ]
-			{469, 297, 662},  // ^
-			{500, -125, -75}, // _
-			{333, 433, 676},  // `
-			{444, -10, 460},  // a
-			{500, -10, 683},  // b
-			{444, -10, 460},  // c
-			{500, -10, 683},  // d
-			{444, -10, 460},  // e
-			{333, 0, 683},    // f
-			{500, -218, 460}, // g
-			{500, 0, 683},    // h
-			{278, 0, 683},    // i
-			{278, -218, 683}, // j
-			{500, 0, 683},    // k
-			{278, 0, 683},    // l
-			{778, 0, 460},    // m
-			{500, 0, 460},    // n
-			{500, -10, 460},  // o
-			{500, -217, 460}, // p
-			{500, -217, 460}, // q
-			{333, 0, 460},    // r
-			{389, -10, 460},  // s
-			{278, -10, 579},  // t
-			{500, -10, 450},  // u
-			{500, -14, 450},  // v
-			{722, -14, 450},  // w
-			{500, 0, 450},    // x
-			{500, -218, 450}, // y
-			{444, 0, 450},    // z
-			{480, -181, 680}, // {
-			{200, -218, 782}, // |
-			{480, -181, 680}, // }
-			{541, 183, 323},  // ~
-		},
-		ligatures: map[[2]byte]charMetrics{
-			{'f', 'i'}: {556, 0, 683},
-			{'f', 'l'}: {556, 0, 683},
-		},
-		kernpairs: map[[2]byte]int16{
-			{'A', 'C'}:   -40,
-			{'A', 'G'}:   -40,
-			{'A', 'O'}:   -55,
-			{'A', 'Q'}:   -55,
-			{'A', 'T'}:   -111,
-			{'A', 'U'}:   -55,
-			{'A', 'V'}:   -135,
-			{'A', 'W'}:   -90,
-			{'A', 'Y'}:   -105,
-			{'A', '\''}:  -111,
-			{'A', 'v'}:   -74,
-			{'A', 'w'}:   -92,
-			{'A', 'y'}:   -92,
-			{'B', 'A'}:   -35,
-			{'B', 'U'}:   -10,
-			{'D', 'A'}:   -40,
-			{'D', 'V'}:   -40,
-			{'D', 'W'}:   -30,
-			{'D', 'Y'}:   -55,
-			{'F', 'A'}:   -74,
-			{'F', 'a'}:   -15,
-			{'F', ','}:   -80,
-			{'F', 'o'}:   -15,
-			{'F', '.'}:   -80,
-			{'J', 'A'}:   -60,
-			{'K', 'O'}:   -30,
-			{'K', 'e'}:   -25,
-			{'K', 'o'}:   -35,
-			{'K', 'u'}:   -15,
-			{'K', 'y'}:   -25,
-			{'L', 'T'}:   -92,
-			{'L', 'V'}:   -100,
-			{'L', 'W'}:   -74,
-			{'L', 'Y'}:   -100,
-			{'L', '\''}:  -92,
-			{'L', 'y'}:   -55,
-			{'N', 'A'}:   -35,
-			{'O', 'A'}:   -35,
-			{'O', 'T'}:   -40,
-			{'O', 'V'}:   -50,
-			{'O', 'W'}:   -35,
-			{'O', 'X'}:   -40,
-			{'O', 'Y'}:   -50,
-			{'P', 'A'}:   -92,
-			{'P', 'a'}:   -15,
-			{'P', ','}:   -111,
-			{'P', '.'}:   -111,
-			{'Q', 'U'}:   -10,
-			{'R', 'O'}:   -40,
-			{'R', 'T'}:   -60,
-			{'R', 'U'}:   -40,
-			{'R', 'V'}:   -80,
-			{'R', 'W'}:   -55,
-			{'R', 'Y'}:   -65,
-			{'T', 'A'}:   -93,
-			{'T', 'O'}:   -18,
-			{'T', 'a'}:   -80,
-			{'T', ':'}:   -50,
-			{'T', ','}:   -74,
-			{'T', 'e'}:   -70,
-			{'T', '-'}:   -92,
-			{'T', 'i'}:   -35,
-			{'T', 'o'}:   -80,
-			{'T', '.'}:   -74,
-			{'T', 'r'}:   -35,
-			{'T', ';'}:   -55,
-			{'T', 'u'}:   -45,
-			{'T', 'w'}:   -80,
-			{'T', 'y'}:   -80,
-			{'U', 'A'}:   -40,
-			{'V', 'A'}:   -135,
-			{'V', 'G'}:   -15,
-			{'V', 'O'}:   -40,
-			{'V', 'a'}:   -111,
-			{'V', ':'}:   -74,
-			{'V', ','}:   -129,
-			{'V', 'e'}:   -111,
-			{'V', '-'}:   -100,
-			{'V', 'i'}:   -60,
-			{'V', 'o'}:   -129,
-			{'V', '.'}:   -129,
-			{'V', ';'}:   -74,
-			{'V', 'u'}:   -75,
-			{'W', 'A'}:   -120,
-			{'W', 'O'}:   -10,
-			{'W', 'a'}:   -80,
-			{'W', ':'}:   -37,
-			{'W', ','}:   -92,
-			{'W', 'e'}:   -80,
-			{'W', '-'}:   -65,
-			{'W', 'i'}:   -40,
-			{'W', 'o'}:   -80,
-			{'W', '.'}:   -92,
-			{'W', ';'}:   -37,
-			{'W', 'u'}:   -50,
-			{'W', 'y'}:   -73,
-			{'Y', 'A'}:   -120,
-			{'Y', 'O'}:   -30,
-			{'Y', 'a'}:   -100,
-			{'Y', ':'}:   -92,
-			{'Y', ','}:   -129,
-			{'Y', 'e'}:   -100,
-			{'Y', '-'}:   -111,
-			{'Y', 'i'}:   -55,
-			{'Y', 'o'}:   -110,
-			{'Y', '.'}:   -129,
-			{'Y', ';'}:   -92,
-			{'Y', 'u'}:   -111,
-			{'a', 'v'}:   -20,
-			{'a', 'w'}:   -15,
-			{'b', '.'}:   -40,
-			{'b', 'u'}:   -20,
-			{'b', 'v'}:   -15,
-			{'c', 'y'}:   -15,
-			{',', '\''}:  -70,
-			{'e', 'g'}:   -15,
-			{'e', 'v'}:   -25,
-			{'e', 'w'}:   -25,
-			{'e', 'x'}:   -15,
-			{'e', 'y'}:   -15,
-			{'f', 'a'}:   -10,
-			{'f', 'f'}:   -25,
-			{'f', 'i'}:   -20,
-			{'f', '\''}:  55,
-			{'g', 'a'}:   -5,
-			{'h', 'y'}:   -5,
-			{'i', 'v'}:   -25,
-			{'k', 'e'}:   -10,
-			{'k', 'o'}:   -10,
-			{'k', 'y'}:   -15,
-			{'l', 'w'}:   -10,
-			{'n', 'v'}:   -40,
-			{'n', 'y'}:   -15,
-			{'o', 'v'}:   -15,
-			{'o', 'w'}:   -25,
-			{'o', 'y'}:   -10,
-			{'p', 'y'}:   -10,
-			{'.', '\''}:  -70,
-			{'`', 'A'}:   -80,
-			{'`', '`'}:   -74,
-			{'\'', 'd'}:  -50,
-			{'\'', 'l'}:  -10,
-			{'\'', '\''}: -74,
-			{'\'', 'r'}:  -50,
-			{'\'', 's'}:  -55,
-			{'\'', ' '}:  -74,
-			{'\'', 't'}:  -18,
-			{'\'', 'v'}:  -50,
-			{'r', ','}:   -40,
-			{'r', 'g'}:   -18,
-			{'r', '-'}:   -20,
-			{'r', '.'}:   -55,
-			{' ', 'A'}:   -55,
-			{' ', 'T'}:   -18,
-			{' ', 'V'}:   -50,
-			{' ', 'W'}:   -30,
-			{' ', 'Y'}:   -90,
-			{'v', 'a'}:   -25,
-			{'v', ','}:   -65,
-			{'v', 'e'}:   -15,
-			{'v', 'o'}:   -20,
-			{'v', '.'}:   -65,
-			{'w', 'a'}:   -10,
-			{'w', ','}:   -65,
-			{'w', 'o'}:   -10,
-			{'w', '.'}:   -65,
-			{'x', 'e'}:   -15,
-			{'y', ','}:   -65,
-			{'y', '.'}:   -65,
-		},
-	},
+// computeHeights sets the maximum heights above and below the baseline from
+// the metrics of the ASCII characters.
+func (fm *fontMetrics) computeHeights() {
+	for _, cm := range fm.chars[:min(len(fm.chars), 95)] {
+		fm.habove = max(fm.habove, cm[2])
+		fm.hbelow = max(fm.hbelow, -cm[1])
+	}
+	for _, cm := range fm.ligatures {
+		fm.habove = max(fm.habove, cm[2])
+		fm.hbelow = max(fm.hbelow, -cm[1])
+	}
 }
@@ -1,7 +1,10 @@
 // Package pdftext provides methods for adding text to PDF files opened with
 // gofpdf, with styling, alignment, and wrapping.  It relies on font metrics,
-// and has embedded font metrics for Courier, Helvetica, and Times-Roman (ASCII
-// characters only).
+// and has embedded font metrics for the standard 14 fonts (for the characters
+// in WinAnsiEncoding).  Metrics for other fonts can be loaded from AFM files
+// with RegisterAFM, or from TrueType and OpenType fonts with RegisterTrueType.
+// DrawContent uses the same layout to generate PDF content streams directly,
+// without gofpdf.
 package pdftext
 
 import (
@@ -14,15 +17,18 @@ import (
 
 // Style is the style for drawing a text string.
 type Style struct {
-	Font        string  // default = "Helvetica"
-	FontSize    float64 // default = 12.0
-	MinFontSize float64 // default = no shrink to fit
-	LineHeight  float64 // as a multiple of font size, default = 1.0
-	Color       []byte  // default = black
-	HAlign      string  // "left" (default), "center", "right"
-	VAlign      string  // "center" (default), "baseline", "top"
-	Wrap        int8    // >0 = true, <=0 = false
-	Clip        int8    // >0 = true, <=0 = false
+	Font        string      // default = "Helvetica"
+	FontSize    float64     // default = 12.0
+	MinFontSize float64     // default = no shrink to fit
+	LineHeight  float64     // as a multiple of font size, default = 1.0
+	Color       []byte      // default = black
+	HAlign      string      // "left" (default), "center", "right", "justify"
+	VAlign      string      // "center" (default), "baseline", "top", "bottom"
+	Wrap        int8        // >0 = true, <=0 = false
+	Clip        int8        // >0 = true, <=0 = false
+	Hyphenator  *Hyphenator // default = no hyphenation
+	Rotation    float64     // degrees counterclockwise, default = none
+	Origin      [2]float64  // of rotation, relative to top left of box
 }
 
 func (s Style) Merge(o Style) Style {
@@ -53,157 +59,371 @@ func (s Style) Merge(o Style) Style {
 	if o.Clip != 0 {
 		s.Clip = o.Clip
 	}
+	if o.Hyphenator != nil {
+		s.Hyphenator = o.Hyphenator
+	}
+	if o.Rotation != 0 {
+		s.Rotation = o.Rotation
+	}
+	if o.Origin != [2]float64{} {
+		s.Origin = o.Origin
+	}
 	return s
 }
 
 // Draw draws the string into specified box on the current page of the PDF with
 // the specified style.  It returns whether the string fit in its box.
+//
+// When wrapping, lines are broken at spaces, after hyphens, dashes, and
+// slashes, at zero-width spaces (U+200B), and at soft hyphens (U+00AD), which
+// are otherwise not drawn.  If the style has a Hyphenator, words are also
+// hyphenated as needed.  If the text doesn't fit even after shrinking, words
+// that are too long for a line are broken between characters.  The "justify"
+// alignment spreads the words of each wrapped line across the full width of
+// the box; the last line of each paragraph is left aligned.
+//
+// If the style has a Rotation, the box and the text in it are rotated together
+// around the Origin point, which is given relative to the top left corner of
+// the box (so the default is to rotate around that corner).  The text is laid
+// out in the box before it is rotated; for example, to draw text reading
+// upward along the left edge of a page, use a box as wide as the page is tall,
+// with its bottom left corner at the bottom left corner of the page and a
+// rotation of 90 degrees around that corner.
 func Draw(pdf *gofpdf.Fpdf, s string, x, y, w, h float64, style Style) (fits bool) {
-	var (
-		lh    float64
-		lines []string
-		top   float64
-	)
-	// Streamline special case of empty string.
-	if strings.TrimSpace(s) == "" {
+	return DrawSpans(pdf, []Span{{Text: s}}, x, y, w, h, style)
+}
+
+// A Span is a piece of text with its own style, for DrawSpans.  Its Font,
+// FontSize, LineHeight, and Color override those of the paragraph style; its
+// other style settings are ignored.
+type Span struct {
+	Text  string
+	Style Style
+}
+
+// DrawSpans draws a paragraph made up of spans of text in different styles into
+// the specified box on the current page of the PDF.  The spans are laid out
+// together, wrapping across span boundaries as needed.  style gives the
+// alignment, wrapping, shrinking, and clipping of the paragraph, and the
+// default font, size, line height, and color of the spans.  When shrinking to
+// fit, all font sizes are reduced in proportion to style.FontSize, which is
+// the one that is limited by MinFontSize.  It returns whether the text fit in
+// its box.
+func DrawSpans(pdf *gofpdf.Fpdf, spans []Span, x, y, w, h float64, style Style) (fits bool) {
+	var lines []line
+
+	// Streamline special case of empty text.
+	if blank(spans) {
 		return true
 	}
+	lines, style, fits = layoutSpans(spans, w, h, style)
+	drawLines(&gofpdfDrawer{pdf: pdf}, lines, x, y, w, h, style)
+	return fits
+}
+
+// layoutSpans lays out a paragraph made up of spans into a box, wrapping and
+// shrinking it as requested by the style.  It returns the resulting lines, the
+// style with which to draw them, and whether they fit in the box.
+func layoutSpans(spans []Span, w, h float64, pstyle Style) (lines []line, style Style, fits bool) {
+	var size float64
+
 	// We need font metrics.
-	if style.Font == "" {
-		style.Font = "Helvetica"
-	}
-	if _, ok := metrics[style.Font]; !ok {
-		panic(fmt.Sprintf("no font metrics for %q", style.Font))
-	}
-	if style.FontSize == 0 {
-		style.FontSize = 12
-	}
+	style = defaultStyle(spans, pstyle)
 	// Wrap the text and shrink to fit, if either is requested.
+	size = style.FontSize
 	for {
-		lh = style.LineHeight * style.FontSize
-		if lh == 0 {
-			lh = style.FontSize
-		}
-		if lines, fits = fitText(s, style.Font, w, h, style.FontSize, lh, style.Wrap > 0); fits {
+		if lines, fits = fitLines(spanRuns(spans, style, size), w, h, style.Wrap > 0, false); fits {
 			break
 		}
-		if style.MinFontSize == 0 || style.FontSize-0.5 < style.MinFontSize {
+		if style.MinFontSize == 0 || size-0.5 < style.MinFontSize {
 			break
 		}
-		style.FontSize -= 0.5
+		size -= 0.5
+	}
+	if !fits && style.Wrap > 0 {
+		// As a last resort, break words that are too long for a line.
+		lines, fits = fitLines(spanRuns(spans, style, size), w, h, true, true)
 	}
 	if !fits {
 		style.VAlign = "top"
 	}
+	return lines, style, fits
+}
+
+// DrawFlow draws as many lines of a paragraph made up of spans as fit
+// vertically into the specified box on the current page of the PDF, and
+// returns the spans that didn't fit, so that they can be continued in another
+// box (e.g., the next column or page).  It also returns the height taken by the
+// lines that were drawn.  rest is nil if all of the text was drawn; it is all
+// of the text if not even one line fits in the box.  The text is laid out as
+// with DrawSpans, except that it is never shrunk to fit and words that are too
+// long for a line are always broken.  If not all of the text fits, it is
+// aligned to the top of the box.
+func DrawFlow(pdf *gofpdf.Fpdf, spans []Span, x, y, w, h float64, style Style) (rest []Span, height float64) {
+	var (
+		lines []line
+		count int
+	)
+	if blank(spans) {
+		return nil, 0
+	}
+	style = defaultStyle(spans, style)
+	lines, _ = fitLines(spanRuns(spans, style, style.FontSize), w, h, style.Wrap > 0, true)
+	// Find out how many lines fit.  As in fitLines, the last line takes
+	// only its font height, not its full line height.
+	var total float64
+	for count < len(lines) {
+		var lh = lines[count].lineHeight()
+		if total+min(lh, lines[count].fontSize()) > h {
+			break
+		}
+		height = total + min(lh, lines[count].fontSize())
+		total += lh
+		count++
+	}
+	if count == 0 {
+		return spans, 0
+	}
+	if count < len(lines) {
+		rest = spansFrom(spans, lines[count].start)
+		lines, style.VAlign = lines[:count], "top"
+	}
+	drawLines(&gofpdfDrawer{pdf: pdf}, lines, x, y, w, h, style)
+	return rest, height
+}
+
+// blank returns whether the spans have no text other than whitespace.
+func blank(spans []Span) bool {
+	return slices.IndexFunc(spans, func(s Span) bool { return strings.TrimSpace(s.Text) != "" }) < 0
+}
+
+// defaultStyle fills in the defaults for the font and font size of the style,
+// and makes sure we have metrics for all of the fonts used by the spans.
+func defaultStyle(spans []Span, style Style) Style {
+	if style.Font == "" {
+		style.Font = "Helvetica"
+	}
+	if style.FontSize == 0 {
+		style.FontSize = 12
+	}
+	for _, span := range spans {
+		if font := style.Merge(span.Style).Font; metrics[font] == nil {
+			panic(fmt.Sprintf("no font metrics for %q", font))
+		}
+	}
+	return style
+}
+
+// spansFrom returns the spans starting at the specified byte offset in their
+// combined text.
+func spansFrom(spans []Span, offset int) (rest []Span) {
+	for _, span := range spans {
+		if offset >= len(span.Text) {
+			offset -= len(span.Text)
+			continue
+		}
+		rest = append(rest, Span{span.Text[offset:], span.Style})
+		offset = 0
+	}
+	return rest
+}
+
+// drawLines draws lines of text into the box.
+func drawLines(d drawer, lines []line, x, y, w, h float64, style Style) {
+	var top float64
+
 	// Figure out where to start vertically.
 	switch style.VAlign {
 	case "top":
-		_, habove, _ := Measure(lines[0], style.Font, style.FontSize)
+		_, habove, _ := lines[0].measure()
 		top = y + habove
+	case "bottom":
+		_, _, hbelow := lines[len(lines)-1].measure()
+		top = y + h - hbelow - linesHeight(lines, 0, 0)
 	case "baseline":
-		habove, hbelow := FontMetrics(style.Font, style.FontSize)
-		height := float64(len(lines)-1)*lh + habove + hbelow
-		top = y + (h-height)/2 + habove
+		habove, _ := lines[0].fontMetrics()
+		_, hbelow := lines[len(lines)-1].fontMetrics()
+		top = y + (h-linesHeight(lines, habove, hbelow))/2 + habove
 	default: // "center"
-		_, habove, _ := Measure(lines[0], style.Font, style.FontSize)
-		_, _, hbelow := Measure(lines[len(lines)-1], style.Font, style.FontSize)
-		height := float64(len(lines)-1)*lh + habove + hbelow
-		top = y + (h-height)/2 + habove
+		_, habove, _ := lines[0].measure()
+		_, _, hbelow := lines[len(lines)-1].measure()
+		top = y + (h-linesHeight(lines, habove, hbelow))/2 + habove
 	}
 	// Set up for drawing.
-	pdf.SetFont(style.Font, "", style.FontSize)
-	if style.Color != nil {
-		pdf.SetTextColor(int(style.Color[0]), int(style.Color[1]), int(style.Color[2]))
-	} else {
-		pdf.SetTextColor(0, 0, 0)
+	var current = lines[0].runs[0].style
+	d.setStyle(current)
+	if style.Rotation != 0 {
+		d.rotate(style.Rotation, x+style.Origin[0], y+style.Origin[1])
 	}
 	if style.Clip > 0 {
-		pdf.ClipRect(x, y, w, h, false)
+		d.clip(x, y, w, h)
 	}
 	// Draw the lines.
-	for _, line := range lines {
-		var left float64
-
+	for i, line := range lines {
+		var (
+			left    float64
+			spacing float64 // extra space per space character, for justification
+		)
+		if i != 0 {
+			top += line.lineHeight()
+		}
 		switch style.HAlign {
+		case "justify":
+			left = x
+			if line.wrapped {
+				var text = line.text()
+				if count := strings.Count(strings.TrimLeft(text, " "), " "); count != 0 {
+					width, _, _ := line.measure()
+					spacing = (w - width) / float64(count)
+				}
+			}
 		case "center":
-			width, _, _ := Measure(line, style.Font, style.FontSize)
+			width, _, _ := line.measure()
 			left = x + (w-width)/2
 		case "right":
-			width, _, _ := Measure(line, style.Font, style.FontSize)
+			width, _, _ := line.measure()
 			left = x + w - width
 		default: // "left"
 			left = x
 		}
-		pdf.Text(left, top, line)
-		top += lh
+		var inText bool // past any leading spaces
+		for _, r := range line.runs {
+			if r.text == "" && len(line.runs) > 1 {
+				continue
+			}
+			if r.style.Font != current.Font || r.style.FontSize != current.FontSize || !slices.Equal(r.style.Color, current.Color) {
+				current = r.style
+				d.setStyle(current)
+			}
+			var pieces = []string{r.text}
+			if spacing != 0 {
+				// Draw each word separately, so that we can
+				// adjust the spaces between them.
+				pieces = strings.SplitAfter(r.text, " ")
+			}
+			for _, piece := range pieces {
+				if piece != "" || len(pieces) == 1 {
+					d.text(left, top, piece)
+				}
+				width, _, _ := Measure(piece, r.style.Font, r.style.FontSize)
+				left += width
+				if strings.TrimLeft(piece, " ") != "" {
+					inText = true
+				}
+				if inText && strings.HasSuffix(piece, " ") {
+					left += spacing
+				}
+			}
+		}
 	}
 	if style.Clip > 0 {
-		pdf.ClipEnd()
+		d.unclip()
+	}
+	if style.Rotation != 0 {
+		d.unrotate()
 	}
-	return fits
 }
 
-// fitText determines whether the string fits in the box at the specified font
-// and size, and how it got word-wrapped in order to fit.
-func fitText(s, font string, w, h, sz, lh float64, wrap bool) (lines []string, fits bool) {
-	// Streamline special case of empty string.
-	if s == "" {
-		return nil, true
-	}
-	// Start by assuming it will fit, until we find out otherwise.
-	var height = h
-	fits = true
-	// Break the string up into lines and handle each one separately.
-	lines = strings.Split(s, "\n")
-	for i := 0; i < len(lines); i++ {
-		var stop = len(lines[i])
-		for {
-			// Measure the line to see if it fits.
-			if width, _, _ := Measure(lines[i][:stop], font, sz); width > w {
-				// It doesn't fit.  Is there a non-initial run
-				// of spaces in it, such that we can word-wrap?
-				if idx := strings.LastIndexByte(lines[i][:stop], ' '); idx > 0 && wrap {
-					for ; idx > 0 && lines[i][idx-1] == ' '; idx-- {
-					}
-					if idx > 0 {
-						// Yes.  Stop the line at that
-						// point and try again.
-						stop = idx
-						continue
-					}
-				}
-				// Can't word wrap (any further).  The whole
-				// value will not fit.  We'll accept truncating
-				// this line, but we'll still continue laying
-				// out the rest of the lines to do the best we
-				// can.
-				fits = false
-			}
-			// Remove the line's vertical size from bbox.
-			height -= lh
-			// If we had to take a tail off the line to word wrap,
-			// put that into the slice as the next line, and remove
-			// it from the current line.
-			var rest int
-			for rest = stop; rest < len(lines[i]) && lines[i][rest] == ' '; rest++ {
-			}
-			if rest < len(lines[i]) {
-				lines = slices.Insert(lines, i+1, lines[i][rest:])
-			}
-			if stop < len(lines[i]) {
-				lines[i] = lines[i][:stop]
-			}
-			// Move on to the next line.
-			break
+// spanRuns returns the runs for a list of spans in a paragraph with the
+// specified style, at the specified (possibly shrunken) font size.
+func spanRuns(spans []Span, style Style, size float64) (runs []run) {
+	for _, span := range spans {
+		var rs = Style{Font: style.Font, FontSize: style.FontSize, LineHeight: style.LineHeight, Color: style.Color, Hyphenator: style.Hyphenator}
+		rs = rs.Merge(Style{Font: span.Style.Font, FontSize: span.Style.FontSize, LineHeight: span.Style.LineHeight, Color: span.Style.Color})
+		if rs.FontSize == style.FontSize {
+			rs.FontSize = size
+		} else {
+			rs.FontSize *= size / style.FontSize
 		}
+		runs = append(runs, run{span.Text, rs})
+	}
+	return runs
+}
+
+// linesHeight returns the height of a set of lines, from the top of the first
+// to the bottom of the last, given those two heights.
+func linesHeight(lines []line, habove, hbelow float64) (height float64) {
+	for _, line := range lines[1:] {
+		height += line.lineHeight()
+	}
+	return height + habove + hbelow
+}
+
+// A drawer draws laid out text.  Coordinates are relative to the top left
+// corner of the page, increasing to the right and downward, as in gofpdf.
+type drawer interface {
+	// setStyle sets the font and text color for drawing a run.
+	setStyle(style Style)
+	// text draws text with its baseline starting at x, y.
+	text(x, y float64, s string)
+	// clip clips drawing to a box, until unclip is called.
+	clip(x, y, w, h float64)
+	unclip()
+	// rotate rotates drawing counterclockwise by angle degrees around x,
+	// y, until unrotate is called.
+	rotate(angle, x, y float64)
+	unrotate()
+}
+
+// gofpdfDrawer draws text on the current page of a gofpdf document.
+type gofpdfDrawer struct {
+	pdf  *gofpdf.Fpdf
+	font string
+}
+
+func (d *gofpdfDrawer) setStyle(style Style) {
+	family, fstyle := gofpdfFont(style.Font)
+	d.pdf.SetFont(family, fstyle, style.FontSize)
+	if style.Color != nil {
+		d.pdf.SetTextColor(int(style.Color[0]), int(style.Color[1]), int(style.Color[2]))
+	} else {
+		d.pdf.SetTextColor(0, 0, 0)
+	}
+	d.font = style.Font
+}
+
+func (d *gofpdfDrawer) text(x, y float64, s string) {
+	if fm := metrics[d.font]; fm.glyphMetrics == nil {
+		s = fm.encode(s)
 	}
-	// For the last line, use the minimum of the font height and the line
-	// height.
-	height = height + lh - min(lh, sz)
-	// Did the value fit vertically?
-	if height < 0 {
-		fits = false
+	d.pdf.Text(x, y, s)
+}
+
+func (d *gofpdfDrawer) clip(x, y, w, h float64) { d.pdf.ClipRect(x, y, w, h, false) }
+func (d *gofpdfDrawer) unclip()                 { d.pdf.ClipEnd() }
+
+func (d *gofpdfDrawer) rotate(angle, x, y float64) {
+	d.pdf.TransformBegin()
+	d.pdf.TransformRotate(angle, x, y)
+}
+
+func (d *gofpdfDrawer) unrotate() { d.pdf.TransformEnd() }
+
+// gofpdfFamilies maps the names of the standard 14 fonts to the family and
+// style names used for them by gofpdf.
+var gofpdfFamilies = map[string][2]string{
+	"Courier":               {"Courier", ""},
+	"Courier-Bold":          {"Courier", "B"},
+	"Courier-Oblique":       {"Courier", "I"},
+	"Courier-BoldOblique":   {"Courier", "BI"},
+	"Helvetica":             {"Helvetica", ""},
+	"Helvetica-Bold":        {"Helvetica", "B"},
+	"Helvetica-Oblique":     {"Helvetica", "I"},
+	"Helvetica-BoldOblique": {"Helvetica", "BI"},
+	"Times-Roman":           {"Times", ""},
+	"Times-Bold":            {"Times", "B"},
+	"Times-Italic":          {"Times", "I"},
+	"Times-BoldItalic":      {"Times", "BI"},
+	"Symbol":                {"Symbol", ""},
+	"ZapfDingbats":          {"ZapfDingbats", ""},
+}
+
+// gofpdfFont returns the gofpdf family and style names for a font.  Fonts
+// other than the standard 14 are expected to have been added to the gofpdf
+// document with their own names as the family name.
+func gofpdfFont(font string) (family, style string) {
+	if fs, ok := gofpdfFamilies[font]; ok {
+		return fs[0], fs[1]
 	}
-	// Return the result.
-	return lines, fits
+	return font, ""
 }
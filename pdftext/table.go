@@ -0,0 +1,254 @@
+package pdftext
+
+import (
+	"strings"
+
+	"github.com/rothskeller/gofpdf"
+)
+
+// A Table draws text in rows and columns, with optional borders and shading.
+// Rows are as tall as needed for their wrapped text, and the table continues on
+// new pages as needed, with the header rows repeated at the top of each page.
+type Table struct {
+	// Columns describes the columns of the table.
+	Columns []Column
+	// Header contains the header rows of the table, which are drawn at the
+	// top of the table and again at the top of each continuation page.
+	Header [][]Cell
+	// Style is the default style for all cells.  Cells are wrapped and
+	// aligned to the top unless their style says otherwise.
+	Style Style
+	// HeaderStyle is merged into Style for header cells.
+	HeaderStyle Style
+	// HeaderFill is the background color of header cells; default = none.
+	HeaderFill []byte
+	// Padding is the space between the borders of a cell and its text.
+	Padding float64
+	// Border is the width of the lines around each cell; default = none.
+	Border float64
+	// BorderColor is the color of the lines around each cell; default =
+	// black.
+	BorderColor []byte
+	// NewPage is called when the next row doesn't fit on the current page.
+	// It must start a new page and return the Y coordinate at which the
+	// table should continue.  By default, the table calls AddPage and
+	// continues at the top margin.
+	NewPage func() (y float64)
+}
+
+// A Column describes a column of a Table.
+type Column struct {
+	// Width is the width of the column.  If it is zero, the column is
+	// sized to fit its widest text, and shrunk (causing the text to wrap)
+	// if needed for the table to fit in its width.
+	Width float64
+	// Style is merged into the table style for cells in the column.
+	Style Style
+}
+
+// A Cell is a cell of a Table.
+type Cell struct {
+	// Text is the text of the cell.
+	Text string
+	// Spans is the text of the cell, in mixed styles.  If it is set, Text
+	// is ignored.
+	Spans []Span
+	// Style is merged into the table and column styles for the cell.
+	Style Style
+	// Fill is the background color of the cell; default = none (or
+	// HeaderFill for header cells).
+	Fill []byte
+}
+
+// spans returns the text of the cell as spans.
+func (c Cell) spans() []Span {
+	if c.Spans != nil {
+		return c.Spans
+	}
+	return []Span{{Text: c.Text}}
+}
+
+// Draw draws the table with its top left corner at x, y on the current page of
+// the PDF.  w is the width available for the table; it only matters if some
+// columns are auto-sized.  A row that doesn't fit above the bottom margin of
+// the page is moved to a new page, unless it is the first row on its page, in
+// which case it is drawn anyway and overflows the page.  Draw returns the Y
+// coordinate of the bottom of the table on the page where it ended, which is
+// the current page when it returns.
+func (t *Table) Draw(pdf *gofpdf.Fpdf, rows [][]Cell, x, y, w float64) (bottom float64) {
+	var (
+		widths []float64
+		limit  float64
+		first  = true // no body rows on this page yet
+	)
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, margin := pdf.GetMargins()
+	limit = pageHeight - margin
+	widths = t.columnWidths(rows, w)
+	y = t.drawRows(pdf, t.Header, true, widths, x, y)
+	for _, row := range rows {
+		var height = t.rowHeight(row, false, widths)
+		if !first && y+height > limit {
+			if t.NewPage != nil {
+				y = t.NewPage()
+			} else {
+				pdf.AddPage()
+				_, y, _, _ = pdf.GetMargins()
+			}
+			y = t.drawRows(pdf, t.Header, true, widths, x, y)
+		}
+		t.drawRow(pdf, row, false, widths, x, y, height)
+		y += height
+		first = false
+	}
+	return y
+}
+
+// drawRows draws rows of the table starting at y, and returns the Y
+// coordinate of the bottom of the last one.
+func (t *Table) drawRows(pdf *gofpdf.Fpdf, rows [][]Cell, header bool, widths []float64, x, y float64) float64 {
+	for _, row := range rows {
+		var height = t.rowHeight(row, header, widths)
+		t.drawRow(pdf, row, header, widths, x, y, height)
+		y += height
+	}
+	return y
+}
+
+// drawRow draws a row of the table.  The fills of all of the cells are drawn
+// before any of the borders, so that no fill covers part of the border of the
+// cell before it.
+func (t *Table) drawRow(pdf *gofpdf.Fpdf, row []Cell, header bool, widths []float64, x, y, height float64) {
+	var (
+		cells = make([]Cell, len(widths))
+		lefts = make([]float64, len(widths))
+	)
+	copy(cells, row)
+	for i, w := range widths {
+		lefts[i] = x
+		x += w
+	}
+	for i, w := range widths {
+		var fill = cells[i].Fill
+		if fill == nil && header {
+			fill = t.HeaderFill
+		}
+		if fill != nil {
+			pdf.SetFillColor(int(fill[0]), int(fill[1]), int(fill[2]))
+			pdf.Rect(lefts[i], y, w, height, "F")
+		}
+	}
+	if t.Border > 0 {
+		if t.BorderColor != nil {
+			pdf.SetDrawColor(int(t.BorderColor[0]), int(t.BorderColor[1]), int(t.BorderColor[2]))
+		} else {
+			pdf.SetDrawColor(0, 0, 0)
+		}
+		pdf.SetLineWidth(t.Border)
+		for i, w := range widths {
+			pdf.Rect(lefts[i], y, w, height, "D")
+		}
+	}
+	for i, w := range widths {
+		if spans := cells[i].spans(); !blank(spans) {
+			var style = t.cellStyle(cells[i], i, header)
+			lines, _ := fitLines(spanRuns(spans, style, style.FontSize), w-2*t.Padding, height, style.Wrap > 0, true)
+			drawLines(&gofpdfDrawer{pdf: pdf}, lines, lefts[i]+t.Padding, y+t.Padding, w-2*t.Padding, height-2*t.Padding, style)
+		}
+	}
+}
+
+// rowHeight returns the height of a row of the table: the height of its
+// tallest cell text, plus padding.
+func (t *Table) rowHeight(row []Cell, header bool, widths []float64) (height float64) {
+	for i, cell := range row {
+		if i >= len(widths) || blank(cell.spans()) {
+			continue
+		}
+		var style = t.cellStyle(cell, i, header)
+		lines, _ := fitLines(spanRuns(cell.spans(), style, style.FontSize), widths[i]-2*t.Padding, 0, style.Wrap > 0, true)
+		height = max(height, textHeight(lines))
+	}
+	return height + 2*t.Padding
+}
+
+// textHeight returns the height of lines of text, computed as fitLines does:
+// the line heights of all lines but the last, plus the smaller of the line
+// height and font size of the last.
+func textHeight(lines []line) (height float64) {
+	for _, line := range lines[:len(lines)-1] {
+		height += line.lineHeight()
+	}
+	var last = lines[len(lines)-1]
+	return height + min(last.lineHeight(), last.fontSize())
+}
+
+// cellStyle returns the style for a cell in the specified column.
+func (t *Table) cellStyle(cell Cell, column int, header bool) (style Style) {
+	style = Style{VAlign: "top", Wrap: 1}.Merge(t.Style)
+	if column < len(t.Columns) {
+		style = style.Merge(t.Columns[column].Style)
+	}
+	if header {
+		style = style.Merge(t.HeaderStyle)
+	}
+	return defaultStyle(cell.spans(), style.Merge(cell.Style))
+}
+
+// columnWidths returns the widths of the columns of the table.  Auto-sized
+// columns get the width of their widest unwrapped text, plus padding.  If that
+// makes the table wider than w, they get the width of their widest word
+// instead, plus a share of any remaining width in proportion to how much more
+// they would need to avoid wrapping.
+func (t *Table) columnWidths(rows [][]Cell, w float64) (widths []float64) {
+	var (
+		minWidths   = make([]float64, len(t.Columns))
+		fixed, auto float64
+		minimum     float64
+	)
+	widths = make([]float64, len(t.Columns))
+	for i, col := range t.Columns {
+		if col.Width != 0 {
+			widths[i] = col.Width
+			fixed += col.Width
+			continue
+		}
+		var measure = func(row []Cell, header bool) {
+			if i >= len(row) || blank(row[i].spans()) {
+				return
+			}
+			var style = t.cellStyle(row[i], i, header)
+			for _, line := range splitLines(spanRuns(row[i].spans(), style, style.FontSize)) {
+				line = line.finish(false)
+				width, _, _ := line.measure()
+				widths[i] = max(widths[i], width+2*t.Padding)
+				for _, r := range line.runs {
+					for _, word := range strings.Fields(r.text) {
+						width, _, _ := Measure(word, r.style.Font, r.style.FontSize)
+						minWidths[i] = max(minWidths[i], width+2*t.Padding)
+					}
+				}
+			}
+		}
+		for _, row := range t.Header {
+			measure(row, true)
+		}
+		for _, row := range rows {
+			measure(row, false)
+		}
+		auto += widths[i]
+		minimum += minWidths[i]
+	}
+	if auto != 0 && fixed+auto > w {
+		var scale float64
+		if auto > minimum {
+			scale = max(w-fixed-minimum, 0) / (auto - minimum)
+		}
+		for i, col := range t.Columns {
+			if col.Width == 0 {
+				widths[i] = minWidths[i] + (widths[i]-minWidths[i])*scale
+			}
+		}
+	}
+	return widths
+}
@@ -0,0 +1,466 @@
+package pdftext
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+/*
+TrueType and OpenType fonts are a table directory followed by tables, each
+identified by a four-character tag.  We use:
+    head	unitsPerEm; indexToLocFormat (for loca)
+    hhea	ascender, descender; numberOfHMetrics (for hmtx)
+    OS/2	sTypoAscender, sTypoDescender (preferred over hhea)
+    hmtx	advance width of each glyph
+    cmap	mapping from Unicode code points to glyphs
+    loca, glyf	bounding box of each glyph (TrueType outlines only)
+    GPOS	pair adjustments of the "kern" feature
+    kern	legacy kerning pairs, used if GPOS has no kerning
+All values are in font units, of which there are unitsPerEm to the em.  They
+are converted to thousandths of an em, as in AFM files, when read.
+*/
+
+// RegisterTrueType reads font metrics from a TrueType or OpenType font file and
+// registers them, so that the font can be used with Measure, FontMetrics, and
+// Draw.  family and style are the family name and style ("", "B", "I", or
+// "BI") under which the font was added to the gofpdf document with
+// AddUTF8Font.  The returned name, which is used as Style.Font, is the family
+// name, followed by a hyphen and the style if the style is not empty.  Unlike
+// the standard fonts, TrueType fonts have metrics for every Unicode character
+// they support.  RegisterTrueType must not be called concurrently with other
+// functions in this package.
+func RegisterTrueType(family, style string, data []byte) (name string, err error) {
+	var fm *fontMetrics
+
+	if fm, err = parseTrueType(data); err != nil {
+		return "", err
+	}
+	name = family
+	if style != "" {
+		name += "-" + style
+	}
+	metrics[name] = fm
+	gofpdfFamilies[name] = [2]string{family, style}
+	return name, nil
+}
+
+// A ttKerning holds the kerning of a TrueType or OpenType font, as a list of
+// lookups.  The adjustments of all lookups that apply to a pair of glyphs are
+// summed.
+type ttKerning [][]ttKernTable
+
+// A ttKernTable is one subtable of a kerning lookup: either a list of glyph
+// pairs or a class-based table.  Within a lookup, only the first subtable that
+// covers the pair is used.
+type ttKernTable struct {
+	pairs    map[[2]uint16]int16
+	coverage map[uint16]bool
+	class1   map[uint16]uint16
+	class2   map[uint16]uint16
+	values   [][]int16 // indexed by class1, class2
+}
+
+// kern returns the kerning adjustment between two glyphs.
+func (k ttKerning) kern(g1, g2 uint16) (adjust int16) {
+	for _, lookup := range k {
+		for _, t := range lookup {
+			if t.pairs != nil {
+				if v, ok := t.pairs[[2]uint16{g1, g2}]; ok {
+					adjust += v
+					break
+				}
+			} else if t.coverage[g1] {
+				if c1, c2 := int(t.class1[g1]), int(t.class2[g2]); c1 < len(t.values) && c2 < len(t.values[c1]) {
+					adjust += t.values[c1][c2]
+				}
+				break
+			}
+		}
+	}
+	return adjust
+}
+
+// ttReader reads big-endian values from a font table, recording the first
+// out-of-range access as an error rather than panicking.
+type ttReader struct {
+	data []byte
+	err  error
+}
+
+func (r *ttReader) u16(off int) uint16 {
+	if off < 0 || off+2 > len(r.data) {
+		if r.err == nil {
+			r.err = errors.New("font table is truncated")
+		}
+		return 0
+	}
+	return binary.BigEndian.Uint16(r.data[off:])
+}
+
+func (r *ttReader) i16(off int) int16 { return int16(r.u16(off)) }
+
+func (r *ttReader) u32(off int) uint32 {
+	return uint32(r.u16(off))<<16 | uint32(r.u16(off+2))
+}
+
+// parseTrueType parses the metrics of a TrueType or OpenType font.
+func parseTrueType(data []byte) (fm *fontMetrics, err error) {
+	var (
+		font       = &ttReader{data: data}
+		tables     = make(map[string]*ttReader)
+		unitsPerEm int
+		numGlyphs  int
+	)
+	// Find the tables.  For a font collection, use the first font.
+	var dir = 0
+	if len(data) >= 12 && string(data[:4]) == "ttcf" {
+		dir = int(font.u32(12))
+	}
+	switch font.u32(dir) {
+	case 0x00010000, 0x4F54544F, 0x74727565: // TrueType, "OTTO", "true"
+		break
+	default:
+		return nil, errors.New("not a TrueType or OpenType font")
+	}
+	for i := 0; i < int(font.u16(dir+4)); i++ {
+		var rec = dir + 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errors.New("font table directory is truncated")
+		}
+		var off, length = int(font.u32(rec + 8)), int(font.u32(rec + 12))
+		if off+length > len(data) || off+length < off {
+			return nil, fmt.Errorf("font table %q is truncated", data[rec:rec+4])
+		}
+		tables[string(data[rec:rec+4])] = &ttReader{data: data[off : off+length]}
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("font has no %q table", tag)
+		}
+	}
+	var head, hhea = tables["head"], tables["hhea"]
+	if unitsPerEm = int(head.u16(18)); unitsPerEm == 0 {
+		return nil, errors.New("font has no unitsPerEm")
+	}
+	var scale = func(v int) int16 {
+		v *= 1000
+		if v < 0 {
+			return int16((v - unitsPerEm/2) / unitsPerEm)
+		}
+		return int16((v + unitsPerEm/2) / unitsPerEm)
+	}
+	fm = new(fontMetrics)
+	// Get the overall heights above and below the baseline.
+	var ascender, descender = int(hhea.i16(4)), int(hhea.i16(6))
+	if os2 := tables["OS/2"]; os2 != nil && len(os2.data) >= 72 {
+		ascender, descender = int(os2.i16(68)), int(os2.i16(70))
+	}
+	fm.habove, fm.hbelow = scale(ascender), scale(-descender)
+	// Get the advance widths.
+	var numHMetrics = int(hhea.u16(34))
+	if maxp := tables["maxp"]; maxp != nil {
+		numGlyphs = int(maxp.u16(4))
+	}
+	if numGlyphs = max(numGlyphs, numHMetrics); numGlyphs == 0 {
+		return nil, errors.New("font has no glyphs")
+	}
+	fm.glyphMetrics = make([]charMetrics, numGlyphs)
+	var hmtx = tables["hmtx"]
+	for g := range fm.glyphMetrics {
+		var aw uint16
+		if g < numHMetrics {
+			aw = hmtx.u16(4 * g)
+		} else if numHMetrics != 0 {
+			aw = hmtx.u16(4 * (numHMetrics - 1))
+		}
+		fm.glyphMetrics[g] = charMetrics{scale(int(aw)), scale(descender), scale(ascender)}
+	}
+	// Get the vertical extents of each glyph, if the font has TrueType
+	// outlines.  Otherwise, we leave them as the font's overall heights.
+	if loca, glyf := tables["loca"], tables["glyf"]; loca != nil && glyf != nil {
+		var long = head.i16(50) != 0
+		for g := range fm.glyphMetrics {
+			var start, end int
+			if long {
+				start, end = int(loca.u32(4*g)), int(loca.u32(4*g+4))
+			} else {
+				start, end = 2*int(loca.u16(2*g)), 2*int(loca.u16(2*g+2))
+			}
+			if end <= start {
+				fm.glyphMetrics[g][1], fm.glyphMetrics[g][2] = 0, 0 // no outline
+			} else {
+				fm.glyphMetrics[g][1], fm.glyphMetrics[g][2] = scale(int(glyf.i16(start+4))), scale(int(glyf.i16(start+8)))
+			}
+		}
+		if loca.err != nil || glyf.err != nil {
+			return nil, errors.New("font glyph tables are truncated")
+		}
+	}
+	if fm.glyphs, err = parseCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	if gpos := tables["GPOS"]; gpos != nil {
+		fm.kerning = parseGPOSKerning(gpos, scale)
+	}
+	if kern := tables["kern"]; kern != nil && fm.kerning == nil {
+		fm.kerning = parseKernTable(kern, scale)
+	}
+	for _, t := range []*ttReader{font, head, hhea, hmtx} {
+		if t.err != nil {
+			return nil, t.err
+		}
+	}
+	return fm, nil
+}
+
+// parseCmap reads the mapping from Unicode code points to glyphs.
+func parseCmap(cmap *ttReader) (glyphs map[rune]uint16, err error) {
+	// Find the best Unicode subtable: a full-repertoire one (format 12) if
+	// there is one, or else a BMP one (format 4).
+	var best, bestFormat int
+	for i := 0; i < int(cmap.u16(2)); i++ {
+		var platform, encoding, off = cmap.u16(4 + 8*i), cmap.u16(6 + 8*i), int(cmap.u32(8 + 8*i))
+		if platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+		if format := int(cmap.u16(off)); (format == 4 || format == 12) && format > bestFormat {
+			best, bestFormat = off, format
+		}
+	}
+	glyphs = make(map[rune]uint16)
+	switch bestFormat {
+	case 0:
+		return nil, errors.New("font has no Unicode cmap")
+	case 4:
+		var segCount = int(cmap.u16(best+6)) / 2
+		var ends, starts, deltas, ranges = best + 14, best + 16 + 2*segCount, best + 16 + 4*segCount, best + 16 + 6*segCount
+		if ranges+2*segCount > len(cmap.data) {
+			return nil, errors.New("font cmap is invalid")
+		}
+		for s := 0; s < segCount && cmap.err == nil; s++ {
+			var end, start, delta, rangeOff = int(cmap.u16(ends + 2*s)), int(cmap.u16(starts + 2*s)), cmap.u16(deltas + 2*s), int(cmap.u16(ranges + 2*s))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				var g uint16
+				if rangeOff == 0 {
+					g = uint16(c) + delta
+				} else if g = cmap.u16(ranges + 2*s + rangeOff + 2*(c-start)); g != 0 {
+					g += delta
+				}
+				if g != 0 {
+					glyphs[rune(c)] = g
+				}
+			}
+		}
+	case 12:
+		// Each group takes 12 bytes; a count that doesn't fit in the
+		// table is bogus, and could be large enough to hang us.
+		var nGroups = int64(cmap.u32(best + 12))
+		if nGroups > int64(len(cmap.data)-best-16)/12 {
+			return nil, errors.New("font cmap is invalid")
+		}
+		for i := 0; i < int(nGroups) && cmap.err == nil; i++ {
+			var grp = best + 16 + 12*i
+			var start, end, g = cmap.u32(grp), cmap.u32(grp + 4), cmap.u32(grp + 8)
+			if end > 0x10FFFF || end < start {
+				return nil, errors.New("font cmap is invalid")
+			}
+			for c := start; c <= end; c++ {
+				glyphs[rune(c)] = uint16(g + c - start)
+			}
+		}
+	}
+	if cmap.err != nil {
+		return nil, cmap.err
+	}
+	return glyphs, nil
+}
+
+// parseGPOSKerning reads the pair adjustment lookups of the "kern" feature in
+// the GPOS table.  It returns nil if there are none, or if the table can't be
+// read.
+func parseGPOSKerning(gpos *ttReader, scale func(int) int16) (kerning ttKerning) {
+	var (
+		featureList = int(gpos.u16(6))
+		lookupList  = int(gpos.u16(8))
+		seen        = make(map[int]bool)
+	)
+	for i := 0; i < int(gpos.u16(featureList)) && gpos.err == nil; i++ {
+		var rec = featureList + 2 + 6*i
+		if rec+4 > len(gpos.data) || string(gpos.data[rec:rec+4]) != "kern" {
+			continue
+		}
+		var feature = featureList + int(gpos.u16(rec+4))
+		for j := 0; j < int(gpos.u16(feature+2)); j++ {
+			var index = int(gpos.u16(feature + 4 + 2*j))
+			if seen[index] {
+				continue
+			}
+			seen[index] = true
+			var lookup = lookupList + int(gpos.u16(lookupList+2+2*index))
+			var ltype = gpos.u16(lookup)
+			var tables []ttKernTable
+			for k := 0; k < int(gpos.u16(lookup+4)); k++ {
+				var sub = lookup + int(gpos.u16(lookup+6+2*k))
+				if ltype == 9 { // extension
+					if gpos.u16(sub+2) != 2 {
+						continue
+					}
+					sub += int(gpos.u32(sub + 4))
+				} else if ltype != 2 {
+					continue
+				}
+				if t, ok := parsePairPos(gpos, sub, scale); ok {
+					tables = append(tables, t)
+				}
+			}
+			if len(tables) != 0 {
+				kerning = append(kerning, tables)
+			}
+		}
+	}
+	if gpos.err != nil {
+		return nil
+	}
+	return kerning
+}
+
+// parsePairPos reads a GPOS pair adjustment subtable.  Only the horizontal
+// advance adjustment of the first glyph is used.
+func parsePairPos(gpos *ttReader, sub int, scale func(int) int16) (t ttKernTable, ok bool) {
+	var (
+		format   = gpos.u16(sub)
+		coverage = parseCoverage(gpos, sub+int(gpos.u16(sub+2)))
+		vf1      = gpos.u16(sub + 4)
+		vf2      = gpos.u16(sub + 6)
+		vsize    = 2 * (bits.OnesCount16(vf1) + bits.OnesCount16(vf2))
+		xadv     = 2 * bits.OnesCount16(vf1&0x3)
+	)
+	if vf1&0x4 == 0 {
+		return t, false // no XAdvance
+	}
+	switch format {
+	case 1:
+		t.pairs = make(map[[2]uint16]int16)
+		for g1, i := range coverage {
+			var set = sub + int(gpos.u16(sub+10+2*i))
+			for j := 0; j < int(gpos.u16(set)) && gpos.err == nil; j++ {
+				var rec = set + 2 + j*(2+vsize)
+				if v := gpos.i16(rec + 2 + xadv); v != 0 {
+					t.pairs[[2]uint16{g1, gpos.u16(rec)}] = scale(int(v))
+				}
+			}
+		}
+	case 2:
+		var (
+			class1Count = int(gpos.u16(sub + 12))
+			class2Count = int(gpos.u16(sub + 14))
+		)
+		// Don't allocate a class matrix bigger than the table could hold.
+		if 16+int64(class1Count)*int64(class2Count)*int64(vsize) > int64(len(gpos.data)-sub) {
+			return t, false
+		}
+		t.coverage = make(map[uint16]bool, len(coverage))
+		for g := range coverage {
+			t.coverage[g] = true
+		}
+		t.class1 = parseClassDef(gpos, sub+int(gpos.u16(sub+8)))
+		t.class2 = parseClassDef(gpos, sub+int(gpos.u16(sub+10)))
+		t.values = make([][]int16, class1Count)
+		for c1 := range t.values {
+			t.values[c1] = make([]int16, class2Count)
+			for c2 := range t.values[c1] {
+				var rec = sub + 16 + (c1*class2Count+c2)*vsize
+				t.values[c1][c2] = scale(int(gpos.i16(rec + xadv)))
+			}
+		}
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+// parseCoverage reads an OpenType coverage table, returning a map from glyph
+// to coverage index.
+func parseCoverage(r *ttReader, off int) (coverage map[uint16]int) {
+	coverage = make(map[uint16]int)
+	switch r.u16(off) {
+	case 1:
+		for i := 0; i < int(r.u16(off+2)) && r.err == nil; i++ {
+			coverage[r.u16(off+4+2*i)] = i
+		}
+	case 2:
+		for i := 0; i < int(r.u16(off+2)) && r.err == nil; i++ {
+			var rec = off + 4 + 6*i
+			var start, end, index = int(r.u16(rec)), int(r.u16(rec + 2)), int(r.u16(rec + 4))
+			for g := start; g <= end; g++ {
+				coverage[uint16(g)] = index + g - start
+			}
+		}
+	}
+	return coverage
+}
+
+// parseClassDef reads an OpenType class definition table, returning a map
+// from glyph to class.  Glyphs not in the map are in class 0.
+func parseClassDef(r *ttReader, off int) (classes map[uint16]uint16) {
+	classes = make(map[uint16]uint16)
+	switch r.u16(off) {
+	case 1:
+		var start = int(r.u16(off + 2))
+		for i := 0; i < int(r.u16(off+4)) && r.err == nil; i++ {
+			classes[uint16(start+i)] = r.u16(off + 6 + 2*i)
+		}
+	case 2:
+		for i := 0; i < int(r.u16(off+2)) && r.err == nil; i++ {
+			var rec = off + 4 + 6*i
+			for g := int(r.u16(rec)); g <= int(r.u16(rec+2)); g++ {
+				classes[uint16(g)] = r.u16(rec + 4)
+			}
+		}
+	}
+	return classes
+}
+
+// parseKernTable reads the horizontal format 0 subtables of a legacy kern
+// table, in either the Microsoft or the Apple layout.  It returns nil if there
+// are none, or if the table can't be read.
+func parseKernTable(kern *ttReader, scale func(int) int16) (kerning ttKerning) {
+	var (
+		apple   = kern.u16(0) == 1
+		nTables int
+		off     int
+		pairs   = make(map[[2]uint16]int16)
+	)
+	if apple {
+		nTables, off = int(kern.u32(4)), 8
+	} else {
+		nTables, off = int(kern.u16(2)), 4
+	}
+	for i := 0; i < nTables && kern.err == nil; i++ {
+		var length, format, horizontal, header int
+		if apple {
+			var coverage = kern.u16(off + 4)
+			length, format, horizontal, header = int(kern.u32(off)), int(coverage&0xFF), int(^coverage>>15&1), 8
+		} else {
+			var coverage = kern.u16(off + 4)
+			length, format, horizontal, header = int(kern.u16(off+2)), int(coverage>>8), int(coverage&1), 6
+		}
+		if format == 0 && horizontal != 0 {
+			var sub = off + header
+			for j := 0; j < int(kern.u16(sub)) && kern.err == nil; j++ {
+				var rec = sub + 8 + 6*j
+				pairs[[2]uint16{kern.u16(rec), kern.u16(rec + 2)}] = scale(int(kern.i16(rec + 4)))
+			}
+		}
+		if length == 0 {
+			break
+		}
+		off += length
+	}
+	if kern.err != nil || len(pairs) == 0 {
+		return nil
+	}
+	return ttKerning{{{pairs: pairs}}}
+}
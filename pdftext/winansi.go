@@ -0,0 +1,74 @@
+package pdftext
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// winAnsiNames are the glyph names of the characters in WinAnsiEncoding,
+// indexed by character code-32.  Codes with no character have empty names.
+var winAnsiNames = [224]string{
+	"space", "exclam", "quotedbl", "numbersign", "dollar", "percent", "ampersand", "quotesingle",
+	"parenleft", "parenright", "asterisk", "plus", "comma", "hyphen", "period", "slash",
+	"zero", "one", "two", "three", "four", "five", "six", "seven",
+	"eight", "nine", "colon", "semicolon", "less", "equal", "greater", "question",
+	"at", "A", "B", "C", "D", "E", "F", "G",
+	"H", "I", "J", "K", "L", "M", "N", "O",
+	"P", "Q", "R", "S", "T", "U", "V", "W",
+	"X", "Y", "Z", "bracketleft", "backslash", "bracketright", "asciicircum", "underscore",
+	"grave", "a", "b", "c", "d", "e", "f", "g",
+	"h", "i", "j", "k", "l", "m", "n", "o",
+	"p", "q", "r", "s", "t", "u", "v", "w",
+	"x", "y", "z", "braceleft", "bar", "braceright", "asciitilde", "",
+	"Euro", "", "quotesinglbase", "florin", "quotedblbase", "ellipsis", "dagger", "daggerdbl",
+	"circumflex", "perthousand", "Scaron", "guilsinglleft", "OE", "", "Zcaron", "",
+	"", "quoteleft", "quoteright", "quotedblleft", "quotedblright", "bullet", "endash", "emdash",
+	"tilde", "trademark", "scaron", "guilsinglright", "oe", "", "zcaron", "Ydieresis",
+	"space", "exclamdown", "cent", "sterling", "currency", "yen", "brokenbar", "section",
+	"dieresis", "copyright", "ordfeminine", "guillemotleft", "logicalnot", "hyphen", "registered", "macron",
+	"degree", "plusminus", "twosuperior", "threesuperior", "acute", "mu", "paragraph", "periodcentered",
+	"cedilla", "onesuperior", "ordmasculine", "guillemotright", "onequarter", "onehalf", "threequarters", "questiondown",
+	"Agrave", "Aacute", "Acircumflex", "Atilde", "Adieresis", "Aring", "AE", "Ccedilla",
+	"Egrave", "Eacute", "Ecircumflex", "Edieresis", "Igrave", "Iacute", "Icircumflex", "Idieresis",
+	"Eth", "Ntilde", "Ograve", "Oacute", "Ocircumflex", "Otilde", "Odieresis", "multiply",
+	"Oslash", "Ugrave", "Uacute", "Ucircumflex", "Udieresis", "Yacute", "Thorn", "germandbls",
+	"agrave", "aacute", "acircumflex", "atilde", "adieresis", "aring", "ae", "ccedilla",
+	"egrave", "eacute", "ecircumflex", "edieresis", "igrave", "iacute", "icircumflex", "idieresis",
+	"eth", "ntilde", "ograve", "oacute", "ocircumflex", "otilde", "odieresis", "divide",
+	"oslash", "ugrave", "uacute", "ucircumflex", "udieresis", "yacute", "thorn", "ydieresis",
+}
+
+// winAnsiHigh maps the characters with codes 128-159 in WinAnsiEncoding
+// (cp1252), which differ from Latin-1, to their codes.
+var winAnsiHigh = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// encode converts a UTF-8 string to the single-byte encoding of a font that
+// isn't a TrueType font: WinAnsiEncoding, or for fonts with their own
+// encoding, the character codes 0-255.  Characters that can't be represented
+// become '?'.  Bytes that aren't valid UTF-8 are passed through unchanged, so
+// that strings that are already encoded are left alone.
+func (fm *fontMetrics) encode(s string) string {
+	var sb strings.Builder
+	for len(s) != 0 {
+		var r, size = utf8.DecodeRuneInString(s)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			sb.WriteByte(s[0])
+		case r >= 32 && r <= 126, fm.fontSpecific && r >= 32 && r <= 255:
+			sb.WriteByte(byte(r))
+		case r >= 0xA0 && r <= 0xFF:
+			sb.WriteByte(byte(r))
+		case winAnsiHigh[r] != 0:
+			sb.WriteByte(winAnsiHigh[r])
+		default:
+			sb.WriteByte('?')
+		}
+		s = s[size:]
+	}
+	return sb.String()
+}